@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/hardboiled/apache-log-parser/webstats"
@@ -38,56 +37,139 @@ func (th TotalHitsAlarm) Do(writer io.Writer) {
 	}
 }
 
-// SectionData hello
-type SectionData struct {
-	LatestTime uint64
-	Window     []webstats.WindowEntry
+// LatencyAlarm reports when the share of slow requests crosses the latency threshold
+type LatencyAlarm struct {
+	SlowHits    uint64
+	Hits        uint64
+	CurrentTime uint64
+	Flag        bool
 }
 
-// Do output of section data
-func (sd *SectionData) Do(writer io.Writer) {
-	totalHitsPerSection := map[string]uint64{}
-	numSections := 0
+// Do prints latency alarms
+func (la LatencyAlarm) Do(writer io.Writer) {
+	fmtStr := "Recovered from high latency alert - slow requests = %d of %d, recovered at %s\n"
+	if la.Flag {
+		fmtStr = "High latency generated an alert - slow requests = %d of %d, triggered at %s\n"
+	}
 
-	for _, sectionsInTimeSlot := range sd.Window {
-		for k, v := range sectionsInTimeSlot.Sections {
-			prev := totalHitsPerSection[k]
-			if prev == 0 {
-				numSections++
-			}
+	_, err := writer.Write([]byte(fmt.Sprintf(fmtStr, la.SlowHits, la.Hits, time.Unix(int64(la.CurrentTime), 0))))
+	if err != nil {
+		fmt.Printf("Error when writing to output: %v\n", err)
+	}
+}
 
-			totalHitsPerSection[k] = prev + v
+// RateLimitViolation lists the clients that went over the per client rate limit
+type RateLimitViolation struct {
+	Offenders   []webstats.ClientHits
+	Limit       uint64
+	Seconds     uint64
+	CurrentTime uint64
+}
+
+// Do prints the offending clients, one per line
+func (rl RateLimitViolation) Do(writer io.Writer) {
+	output := []string{
+		fmt.Sprintf(
+			"Rate limit exceeded - more than %d requests in %d seconds, detected at %s",
+			rl.Limit, rl.Seconds, time.Unix(int64(rl.CurrentTime), 0),
+		),
+	}
+	for _, v := range rl.Offenders {
+		if v.Uncertain > 0 {
+			output = append(output, fmt.Sprintf("\t %s -> hits: %d (up to %d more uncertain)", v.RemoteHost, v.Hits, v.Uncertain))
+			continue
 		}
+		output = append(output, fmt.Sprintf("\t %s -> hits: %d", v.RemoteHost, v.Hits))
 	}
 
-	numberOfSectionsToPrint := 5
-	if numSections < numberOfSectionsToPrint {
-		numberOfSectionsToPrint = numSections
+	_, err := writer.Write([]byte(strings.Join(output, "\n") + "\n"))
+	if err != nil {
+		fmt.Printf("Error when writing to output: %v\n", err)
+	}
+}
+
+// ConfigReloaded reports that new settings were applied without restarting
+type ConfigReloaded struct {
+	CurrentTime uint64
+}
+
+// Do prints when the config was reloaded
+func (cr ConfigReloaded) Do(writer io.Writer) {
+	_, err := writer.Write([]byte(fmt.Sprintf("Config reloaded at %s\n", time.Unix(int64(cr.CurrentTime), 0))))
+	if err != nil {
+		fmt.Printf("Error when writing to output: %v\n", err)
+	}
+}
+
+// SectionData hello
+type SectionData struct {
+	LatestTime    uint64 // last second of the range
+	Window        []webstats.WindowEntry
+	NumTopClients int    // number of remote hosts to print, 0 prints none
+	Resolution    uint64 // seconds each entry in Window covers, 0 is the same as 1
+}
+
+// Start returns the first second of the range
+func (sd *SectionData) Start() uint64 {
+	resolution := sd.Resolution
+	if resolution == 0 {
+		resolution = 1
 	}
+	return sd.LatestTime + 1 - uint64(len(sd.Window))*resolution
+}
+
+type topHit struct {
+	name string
+	hits uint64
+}
 
-	type topSection struct {
-		name string
-		hits uint64
+// topHits removes and returns the n highest counts in descending order
+func topHits(counts map[string]uint64, n int) []topHit {
+	if len(counts) < n {
+		n = len(counts)
 	}
-	topSectionsOrderedDesc := []topSection{}
 
-	for i := 0; i < numberOfSectionsToPrint; i++ {
+	topOrderedDesc := []topHit{}
+	for i := 0; i < n; i++ {
 		curMax := uint64(0)
-		curSection := ""
-		for k, v := range totalHitsPerSection {
+		curName := ""
+		for k, v := range counts {
 			if v >= curMax {
 				curMax = v
-				curSection = k
+				curName = k
 			}
 		}
-		delete(totalHitsPerSection, curSection)
+		delete(counts, curName)
+
+		topOrderedDesc = append(topOrderedDesc, topHit{curName, curMax})
+	}
+
+	return topOrderedDesc
+}
+
+// Do output of section data
+func (sd *SectionData) Do(writer io.Writer) {
+	totalHitsPerSection := map[string]uint64{}
+	totalHitsPerClient := map[string]uint64{}
+	totalHitsPerGroup := map[string]uint64{}
 
-		topSectionsOrderedDesc = append(topSectionsOrderedDesc, topSection{curSection, curMax})
+	for _, timeSlot := range sd.Window {
+		for k, v := range timeSlot.Sections {
+			totalHitsPerSection[k] += v
+		}
+		for k, v := range timeSlot.Clients {
+			totalHitsPerClient[k] += v
+		}
+		for k, v := range timeSlot.ClientGroups {
+			totalHitsPerGroup[k] += v
+		}
 	}
 
+	topSectionsOrderedDesc := topHits(totalHitsPerSection, 5)
+
 	output := []string{}
 
-	begin := time.Unix(int64(sd.LatestTime-uint64(len(sd.Window)-1)), 0)
+	begin := time.Unix(int64(sd.Start()), 0)
 	output = append(
 		output,
 		fmt.Sprintf("Stats for time range %s - %s", begin, time.Unix(int64(sd.LatestTime), 0)),
@@ -104,6 +186,22 @@ func (sd *SectionData) Do(writer io.Writer) {
 		output = append(output, fmt.Sprintf("\t %s -> hits: %d", v.name, v.hits))
 	}
 
+	topClientsOrderedDesc := topHits(totalHitsPerClient, sd.NumTopClients)
+	if len(topClientsOrderedDesc) > 0 {
+		output = append(output, "\ttop clients for this window")
+	}
+	for _, v := range topClientsOrderedDesc {
+		output = append(output, fmt.Sprintf("\t %s -> hits: %d", v.name, v.hits))
+	}
+
+	groupsOrderedDesc := topHits(totalHitsPerGroup, len(totalHitsPerGroup))
+	if len(groupsOrderedDesc) > 0 {
+		output = append(output, "\thits per client group")
+	}
+	for _, v := range groupsOrderedDesc {
+		output = append(output, fmt.Sprintf("\t %s -> hits: %d", v.name, v.hits))
+	}
+
 	result := strings.Join(output, "\n") + "\n"
 	_, err := writer.Write([]byte(result))
 	if err != nil {
@@ -111,10 +209,51 @@ func (sd *SectionData) Do(writer io.Writer) {
 	}
 }
 
-// ProcessStats runs calculations and prints results
-func ProcessStats(ch chan ProcessAndOutputData, writer io.Writer, wg *sync.WaitGroup) {
-	for val := range ch {
-		val.Do(writer)
-		wg.Done()
+// Summary is printed once, after input has been read to the end or the process was asked to stop
+type Summary struct {
+	StopReason     string
+	LinesRead      uint64
+	LinesCounted   uint64 // lines left after filters and excluded clients
+	LateLines      uint64 // lines older than the window
+	LateDataPolicy string
+	ReorderedLines uint64 // lines that arrived after their interval was printed
+	FirstTime      uint64
+	LatestTime     uint64
+	TotalHitsAlarm bool // alarms that were still active when processing stopped
+	LatencyAlarm   bool
+	Hits           uint64 // hits over the last 2 mins
+	SlowHits       uint64
+}
+
+// Do prints the summary
+func (s Summary) Do(writer io.Writer) {
+	output := []string{fmt.Sprintf("Summary - stopped after %s", s.StopReason)}
+	if s.LinesRead == 0 {
+		output = append(output, "\tno log lines were read")
+	} else {
+		output = append(
+			output,
+			fmt.Sprintf("\tlines read %d, lines counted %d", s.LinesRead, s.LinesCounted),
+			fmt.Sprintf("\ttime range %s - %s", time.Unix(int64(s.FirstTime), 0), time.Unix(int64(s.LatestTime), 0)),
+		)
+	}
+
+	if s.ReorderedLines > 0 {
+		output = append(output, fmt.Sprintf("\tlines that arrived after their interval was printed %d", s.ReorderedLines))
+	}
+	if s.LateLines > 0 {
+		output = append(output, fmt.Sprintf("\tlines older than the window %d (%s)", s.LateLines, s.LateDataPolicy))
+	}
+
+	if s.TotalHitsAlarm {
+		output = append(output, fmt.Sprintf("\thigh traffic alert still active - hits = %d", s.Hits))
+	}
+	if s.LatencyAlarm {
+		output = append(output, fmt.Sprintf("\thigh latency alert still active - slow requests = %d of %d", s.SlowHits, s.Hits))
+	}
+
+	_, err := writer.Write([]byte(strings.Join(output, "\n") + "\n"))
+	if err != nil {
+		fmt.Printf("Error when writing to output: %v\n", err)
 	}
 }
@@ -0,0 +1,54 @@
+package analytics_test
+
+import (
+	"bytes"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/webstats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SectionData", func() {
+	It("prints top clients for the window", func() {
+		sd := analytics.SectionData{
+			LatestTime: 1549574341,
+			Window: []webstats.WindowEntry{
+				{Sections: map[string]uint64{"/api": 3}, Clients: map[string]uint64{"10.0.0.1": 2, "10.0.0.2": 1}, TotalHitsForTimeSlot: 3},
+				{Sections: map[string]uint64{"/api": 2}, Clients: map[string]uint64{"10.0.0.2": 2}, TotalHitsForTimeSlot: 2},
+			},
+			NumTopClients: 1,
+		}
+
+		var buf bytes.Buffer
+		sd.Do(&buf)
+		Expect(buf.String()).To(ContainSubstring("total hits for this window 5"))
+		Expect(buf.String()).To(ContainSubstring("top clients for this window\n\t 10.0.0.2 -> hits: 3"))
+		Expect(buf.String()).ToNot(ContainSubstring("10.0.0.1"))
+	})
+})
+
+var _ = Describe("SectionData ranges", func() {
+	It("starts the range at the first second of its first entry", func() {
+		sd := analytics.SectionData{LatestTime: 1549574399, Window: make([]webstats.WindowEntry, 2)}
+		Expect(sd.Start()).To(Equal(uint64(1549574398)))
+
+		sd.Resolution = 60
+		Expect(sd.Start()).To(Equal(uint64(1549574280)))
+	})
+})
+
+var _ = Describe("RateLimitViolation", func() {
+	It("prints the hits that are uncertain", func() {
+		rl := analytics.RateLimitViolation{
+			Offenders: []webstats.ClientHits{{RemoteHost: "10.0.0.1", Hits: 12}, {RemoteHost: "10.0.0.2", Hits: 11, Uncertain: 3}},
+			Limit:     10,
+			Seconds:   5,
+		}
+
+		var buf bytes.Buffer
+		rl.Do(&buf)
+		Expect(buf.String()).To(ContainSubstring("\t 10.0.0.1 -> hits: 12\n"))
+		Expect(buf.String()).To(ContainSubstring("\t 10.0.0.2 -> hits: 11 (up to 3 more uncertain)\n"))
+	})
+})
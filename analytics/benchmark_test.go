@@ -0,0 +1,36 @@
+package analytics_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/webstats"
+)
+
+func BenchmarkSectionDataDo(b *testing.B) {
+	// a 10 second interval with 50 sections, 200 clients and 3 client groups in each second
+	window := make([]webstats.WindowEntry, 10)
+	for i := range window {
+		window[i] = webstats.WindowEntry{
+			Sections:     map[string]uint64{},
+			Clients:      map[string]uint64{},
+			ClientGroups: map[string]uint64{"internal": 10, "office": 5, "partners": 1},
+		}
+		for j := 0; j < 50; j++ {
+			window[i].Sections[fmt.Sprintf("/section%d", j)] = uint64(j + 1)
+		}
+		for j := 0; j < 200; j++ {
+			window[i].Clients[fmt.Sprintf("10.0.%d.%d", i, j)] = uint64(j%7 + 1)
+		}
+		window[i].TotalHitsForTimeSlot = 1275
+	}
+	sd := &analytics.SectionData{LatestTime: 1549573869, Window: window, NumTopClients: 5}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sd.Do(ioutil.Discard)
+	}
+}
@@ -2,7 +2,7 @@ package analytics
 
 import "fmt"
 
-// BufferForOverlappingLogTimes exported for testing
+// BufferForOverlappingLogTimes is the default reorder tolerance, exported for testing
 const BufferForOverlappingLogTimes = uint64(5)
 
 // ScheduleInterval helps determine when to output interval statistics, specifically `SectionData`
@@ -10,13 +10,16 @@ type ScheduleInterval struct {
 	lastTimeProcessed uint64
 	secondsAgo        uint64
 	timeToProcess     uint64
+	reorderTolerance  uint64
+	lateLines         uint64
+	alignment         uint64 // intervals end on the last second of a multiple of this, see `AlignTo`
 }
 
 // ReadyToProcess returns true if the interval should be outputted
 func (sp *ScheduleInterval) ReadyToProcess(nextDate uint64) bool {
 	// shouldSchedule works in concert with shouldProcess. An interval is scheduled to be processed
 	// based on the nextDate, and then after we've read in a few more lines based on the
-	// `bufferForOverlappingLogTimes`, we assume that the interval has been completely read in and
+	// `reorderTolerance`, we assume that the interval has been completely read in and
 	// then it's available to be processed.
 	if !sp.isScheduled() && sp.shouldSchedule(nextDate) {
 		sp.schedule(nextDate)
@@ -35,38 +38,98 @@ func (sp *ScheduleInterval) SecondsAgo() uint64 {
 	return sp.secondsAgo
 }
 
+// CountIfLate counts a line as late if its interval was already processed. It's only called for lines that
+// are counted in the stats, so lines that are filtered out aren't reported as late.
+func (sp *ScheduleInterval) CountIfLate(date uint64) {
+	if date <= sp.lastTimeProcessed {
+		sp.lateLines++ // arrived later than the tolerance, its interval was already processed
+	}
+}
+
+// LateLines returns how many lines arrived after their interval was already processed
+func (sp *ScheduleInterval) LateLines() uint64 {
+	return sp.lateLines
+}
+
 // LastTimeProcessed getter for `lastTimeProcessed`
 func (sp *ScheduleInterval) LastTimeProcessed() uint64 {
 	return sp.lastTimeProcessed
 }
 
+// IsScheduled returns true if an interval is waiting to be processed
+func (sp *ScheduleInterval) IsScheduled() bool {
+	return sp.isScheduled()
+}
+
 // MarkAsProcessed should be called after interval has been processed
 func (sp *ScheduleInterval) MarkAsProcessed() {
 	sp.lastTimeProcessed = sp.timeToProcess
 	sp.timeToProcess = 0
 }
 
-// InitScheduleInterval initializes the ScheduleInterval structure properly
-func InitScheduleInterval(startTime, interval uint64) (ScheduleInterval, error) {
-	if startTime < BufferForOverlappingLogTimes {
-		return ScheduleInterval{}, fmt.Errorf("Start time can't be less than %d", BufferForOverlappingLogTimes)
+// ScheduleState is the progress of a ScheduleInterval, so it can be restored after a restart
+type ScheduleState struct {
+	LastTimeProcessed uint64
+	TimeToProcess     uint64
+	LateLines         uint64
+}
+
+// State returns the progress of the schedule
+func (sp *ScheduleInterval) State() ScheduleState {
+	return ScheduleState{
+		LastTimeProcessed: sp.lastTimeProcessed,
+		TimeToProcess:     sp.timeToProcess,
+		LateLines:         sp.lateLines,
+	}
+}
+
+// Restore continues the schedule from a previous state, the interval and tolerance are kept as initialized
+func (sp *ScheduleInterval) Restore(state ScheduleState) {
+	sp.lastTimeProcessed = state.LastTimeProcessed
+	sp.timeToProcess = state.TimeToProcess
+	sp.lateLines = state.LateLines
+}
+
+// InitScheduleInterval initializes the ScheduleInterval structure properly. An interval is processed once
+// a line more than reorderTolerance seconds after it arrives, so lines can be out of order by that much.
+func InitScheduleInterval(startTime, interval, reorderTolerance uint64) (ScheduleInterval, error) {
+	if startTime < reorderTolerance {
+		return ScheduleInterval{}, fmt.Errorf("Start time can't be less than %d", reorderTolerance)
 	}
-	if interval < BufferForOverlappingLogTimes {
-		return ScheduleInterval{}, fmt.Errorf("interval can't be less than %d", BufferForOverlappingLogTimes)
+	if interval < 1 {
+		return ScheduleInterval{}, fmt.Errorf("interval can't be less than 1")
 	}
 
 	return ScheduleInterval{
-		// we return a `lastTimeProcessed` as startTime - bufferForOverlappingLogTimes
+		// we return a `lastTimeProcessed` as startTime - reorderTolerance
 		// because apache log lines are not guaranteed to be printed exactly in order by time
 		// so we backtrack a few seconds to avoid missing older log lines that are after the
 		// first line in the input
-		lastTimeProcessed: startTime - BufferForOverlappingLogTimes,
+		lastTimeProcessed: startTime - reorderTolerance,
 		secondsAgo:        interval - 1,
+		reorderTolerance:  reorderTolerance,
 	}, nil
 }
 
+// AlignTo makes intervals start and end on multiples of alignment seconds, so that they can be read
+// from a rollup with that resolution. The interval has to be a multiple of alignment. The first interval
+// starts at the multiple before the one InitScheduleInterval starts at, and an interval that's scheduled
+// after a gap in the input ends at the last multiple before the line that scheduled it.
+func (sp *ScheduleInterval) AlignTo(alignment uint64) error {
+	if alignment < 1 || (sp.secondsAgo+1)%alignment != 0 {
+		return fmt.Errorf("interval of %d seconds isn't a multiple of %d seconds", sp.secondsAgo+1, alignment)
+	}
+	if sp.lastTimeProcessed+1 < alignment {
+		return fmt.Errorf("Start time can't be less than %d", alignment)
+	}
+
+	sp.lastTimeProcessed = (sp.lastTimeProcessed+1)/alignment*alignment - 1
+	sp.alignment = alignment
+	return nil
+}
+
 func (sp *ScheduleInterval) shouldProcess(nextDate uint64) bool {
-	return sp.isScheduled() && nextDate > sp.timeToProcess+BufferForOverlappingLogTimes
+	return sp.isScheduled() && nextDate > sp.timeToProcess+sp.reorderTolerance
 }
 
 func (sp *ScheduleInterval) shouldSchedule(nextDate uint64) bool {
@@ -74,6 +137,10 @@ func (sp *ScheduleInterval) shouldSchedule(nextDate uint64) bool {
 }
 
 func (sp *ScheduleInterval) schedule(scheduleDate uint64) {
+	if sp.alignment > 1 {
+		// lastTimeProcessed is aligned, so this is still at least an interval after it
+		scheduleDate = (scheduleDate+1)/sp.alignment*sp.alignment - 1
+	}
 	sp.timeToProcess = scheduleDate
 }
 
@@ -15,17 +15,22 @@ func TestScheduleInterval(t *testing.T) {
 
 var _ = Describe("InitScheduleInterval", func() {
 	It("fails for numbers below buffer lines", func() {
-		_, err := analytics.InitScheduleInterval(uint64(analytics.BufferForOverlappingLogTimes-1), uint64(analytics.BufferForOverlappingLogTimes))
+		_, err := analytics.InitScheduleInterval(uint64(analytics.BufferForOverlappingLogTimes-1), uint64(analytics.BufferForOverlappingLogTimes), analytics.BufferForOverlappingLogTimes)
 		Expect(err).ToNot(BeNil())
 	})
 
-	It("fails for interval below buffer lines", func() {
-		_, err := analytics.InitScheduleInterval(uint64(analytics.BufferForOverlappingLogTimes), uint64(analytics.BufferForOverlappingLogTimes-1))
+	It("fails for interval below 1", func() {
+		_, err := analytics.InitScheduleInterval(uint64(analytics.BufferForOverlappingLogTimes), 0, analytics.BufferForOverlappingLogTimes)
 		Expect(err).ToNot(BeNil())
 	})
 
 	It("succeeds on normal params", func() {
-		_, err := analytics.InitScheduleInterval(uint64(analytics.BufferForOverlappingLogTimes), uint64(analytics.BufferForOverlappingLogTimes))
+		_, err := analytics.InitScheduleInterval(uint64(analytics.BufferForOverlappingLogTimes), uint64(analytics.BufferForOverlappingLogTimes), analytics.BufferForOverlappingLogTimes)
+		Expect(err).To(BeNil())
+	})
+
+	It("allows intervals shorter than the reorder tolerance", func() {
+		_, err := analytics.InitScheduleInterval(uint64(analytics.BufferForOverlappingLogTimes), 1, analytics.BufferForOverlappingLogTimes)
 		Expect(err).To(BeNil())
 	})
 })
@@ -34,7 +39,7 @@ var _ = Describe("ScheduleInterval", func() {
 	It("schedules intervals regularly", func() {
 		interval := uint64(8)
 		startTime := uint64(analytics.BufferForOverlappingLogTimes)
-		si, _ := analytics.InitScheduleInterval(startTime, interval)
+		si, _ := analytics.InitScheduleInterval(startTime, interval, analytics.BufferForOverlappingLogTimes)
 
 		// add 1 for first time, since InitScheduleInterval has already marked startTime as lastTimeProcessed.
 		// Thus, we need to advance the counter to the next time to start the interval
@@ -57,4 +62,40 @@ var _ = Describe("ScheduleInterval", func() {
 
 		Expect(secondIteration).To(Equal(interval))
 	})
+
+	It("waits for the reorder tolerance and counts lines later than it", func() {
+		startTime := uint64(100)
+		si, _ := analytics.InitScheduleInterval(startTime, 10, 2)
+
+		Expect(si.ReadyToProcess(startTime + 8)).To(Equal(false)) // schedules [99, 108]
+		Expect(si.TimeToProcess()).To(Equal(startTime + 8))
+		Expect(si.ReadyToProcess(startTime + 10)).To(Equal(false))
+		Expect(si.ReadyToProcess(startTime + 11)).To(Equal(true))
+		si.MarkAsProcessed()
+
+		Expect(si.LateLines()).To(Equal(uint64(0)))
+		si.CountIfLate(startTime + 8)
+		si.CountIfLate(startTime + 1)
+		si.CountIfLate(startTime + 9)
+		Expect(si.LateLines()).To(Equal(uint64(2)))
+	})
+
+	It("aligns intervals to a resolution", func() {
+		startTime := uint64(1000)
+		si, _ := analytics.InitScheduleInterval(startTime, 120, 5)
+		Expect(si.AlignTo(60)).To(BeNil())
+		Expect(si.LastTimeProcessed()).To(Equal(uint64(959)))
+
+		Expect(si.ReadyToProcess(1079)).To(Equal(false)) // schedules [960, 1079]
+		Expect(si.TimeToProcess()).To(Equal(uint64(1079)))
+		Expect(si.ReadyToProcess(1085)).To(Equal(true))
+		si.MarkAsProcessed()
+
+		// after a gap, the interval ends at the minute before the line that scheduled it
+		Expect(si.ReadyToProcess(1350)).To(Equal(true)) // past the tolerance of the interval it schedules
+		Expect(si.TimeToProcess()).To(Equal(uint64(1319)))
+
+		other, _ := analytics.InitScheduleInterval(startTime, 90, 5)
+		Expect(other.AlignTo(60)).ToNot(BeNil())
+	})
 })
@@ -0,0 +1,12 @@
+/*Package checkpoint defines where a line ends in a followed file, so the position can be passed along
+ * with the parsed line and saved once it's processed, without the parser depending on the follower
+ */
+package checkpoint
+
+// Position is where a line ends in a file. The file is identified by its device and inode,
+// so a position still refers to the same file after it's rotated.
+type Position struct {
+	Device uint64
+	Inode  uint64
+	Offset int64
+}
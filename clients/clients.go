@@ -0,0 +1,95 @@
+/*Package clients groups remote hosts by CIDR so that traffic can be labeled
+ * (e.g. internal, office, monitoring) or excluded from stats entirely
+ */
+package clients
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+type labeledNetwork struct {
+	label   string
+	network *net.IPNet
+}
+
+// Classifier labels and filters remote hosts based on CIDR lists
+type Classifier struct {
+	groups   []labeledNetwork // sorted by prefix length desc, so the most specific network matches first
+	excluded []*net.IPNet
+}
+
+// InitClassifier parses the CIDRs for each group label and the CIDRs to exclude
+func InitClassifier(groups map[string][]string, excluded []string) (Classifier, error) {
+	classifier := Classifier{}
+
+	for label, cidrs := range groups {
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return Classifier{}, fmt.Errorf("invalid CIDR %q for client group %s: %v", cidr, label, err)
+			}
+			classifier.groups = append(classifier.groups, labeledNetwork{label, network})
+		}
+	}
+
+	sort.Slice(classifier.groups, func(i, j int) bool {
+		iOnes, _ := classifier.groups[i].network.Mask.Size()
+		jOnes, _ := classifier.groups[j].network.Mask.Size()
+		if iOnes == jOnes {
+			return classifier.groups[i].label < classifier.groups[j].label
+		}
+		return iOnes > jOnes
+	})
+
+	for _, cidr := range excluded {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return Classifier{}, fmt.Errorf("invalid excluded CIDR %q: %v", cidr, err)
+		}
+		classifier.excluded = append(classifier.excluded, network)
+	}
+
+	return classifier, nil
+}
+
+// Label returns the label of the most specific group containing remoteHost, or "" if there isn't one
+func (c *Classifier) Label(remoteHost string) string {
+	if len(c.groups) == 0 {
+		return ""
+	}
+
+	ip := net.ParseIP(remoteHost)
+	if ip == nil {
+		return ""
+	}
+
+	for _, g := range c.groups {
+		if g.network.Contains(ip) {
+			return g.label
+		}
+	}
+
+	return ""
+}
+
+// IsExcluded returns true if remoteHost should be left out of stats
+func (c *Classifier) IsExcluded(remoteHost string) bool {
+	if len(c.excluded) == 0 {
+		return false
+	}
+
+	ip := net.ParseIP(remoteHost)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range c.excluded {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,46 @@
+package clients_test
+
+import (
+	"testing"
+
+	"github.com/hardboiled/apache-log-parser/clients"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestClients(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Clients Suite")
+}
+
+var _ = Describe("Classifier", func() {
+	It("fails on invalid CIDRs", func() {
+		_, err := clients.InitClassifier(map[string][]string{"office": {"10.0.0.0/33"}}, nil)
+		Expect(err).ToNot(BeNil())
+
+		_, err = clients.InitClassifier(nil, []string{"not-a-cidr"})
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("labels clients with the most specific group", func() {
+		c, err := clients.InitClassifier(map[string][]string{
+			"internal":   {"10.0.0.0/8"},
+			"monitoring": {"10.0.5.0/24", "2001:db8::/32"},
+		}, nil)
+		Expect(err).To(BeNil())
+
+		Expect(c.Label("10.1.2.3")).To(Equal("internal"))
+		Expect(c.Label("10.0.5.9")).To(Equal("monitoring"))
+		Expect(c.Label("2001:db8::1")).To(Equal("monitoring"))
+		Expect(c.Label("192.168.0.1")).To(Equal(""))
+		Expect(c.Label("example.com")).To(Equal(""))
+	})
+
+	It("excludes clients in excluded CIDRs", func() {
+		c, err := clients.InitClassifier(nil, []string{"10.0.5.0/24"})
+		Expect(err).To(BeNil())
+
+		Expect(c.IsExcluded("10.0.5.1")).To(Equal(true))
+		Expect(c.IsExcluded("10.0.6.1")).To(Equal(false))
+	})
+})
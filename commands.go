@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/clients"
+	"github.com/hardboiled/apache-log-parser/export"
+	"github.com/hardboiled/apache-log-parser/filter"
+	"github.com/hardboiled/apache-log-parser/manage"
+	"github.com/hardboiled/apache-log-parser/parsing"
+	"github.com/hardboiled/apache-log-parser/pipeline"
+	"github.com/hardboiled/apache-log-parser/report"
+	"gopkg.in/yaml.v2"
+)
+
+// checkFormatLines is how many lines check-format shows
+const checkFormatLines = 10
+
+// legacyCommand is the command line from before subcommands, it reads or follows the input depending on -follow
+func legacyCommand(name string, args []string) error {
+	return analyze(name, args, nil, stdoutSink)
+}
+
+func runCommand(name string, args []string) error {
+	return analyze(name, args, func(o *manage.Options) {
+		o.Follow = true
+	}, stdoutSink)
+}
+
+func replayCommand(name string, args []string) error {
+	return analyze(name, args, func(o *manage.Options) {
+		o.Follow = false
+	}, stdoutSink)
+}
+
+// reportCommand reads the whole file as fast as possible and prints a single report at the end
+func reportCommand(name string, args []string) error {
+	return analyze(name, args, func(o *manage.Options) {
+		o.Follow = false
+		o.ReplaySpeed = "max"
+		o.StateFilepath = ""
+	}, func(config manage.Config) pipeline.Sink {
+		return report.New(os.Stdout, config)
+	})
+}
+
+// stdoutSink prints intervals and alarms as they happen
+func stdoutSink(manage.Config) pipeline.Sink {
+	return pipeline.WriterSink(os.Stdout)
+}
+
+// analyze runs the pipeline over the input, with the sink newSink creates for the config. SIGINT/SIGTERM
+// stop it after flushing what was already read, and SIGHUP reloads the config.
+func analyze(name string, args []string, adjust func(*manage.Options), newSink func(manage.Config) pipeline.Sink) error {
+	config, _, err := loadConfig(name, args, adjust)
+	if err != nil {
+		return err
+	}
+
+	reader, err := pipeline.OpenInput(config)
+	if err != nil {
+		return fmt.Errorf("error opening input file: %v", err)
+	}
+	defer reader.Close()
+
+	sinks, closeExports, err := openExports(config)
+	if err != nil {
+		return err
+	}
+	sinks = append([]pipeline.Sink{newSink(config)}, sinks...)
+
+	// SIGINT/SIGTERM stop reading input, everything already read is still flushed by the pipeline
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM) // a second signal kills the process without waiting for the flush
+		cancel()
+	}()
+
+	p := pipeline.New(config, sinks...)
+
+	// SIGHUP reloads the config file, e.g. to raise the alarm threshold during an incident without losing the window
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			newConfig, _, err := loadConfig(name, args, adjust)
+			if err == nil {
+				err = p.Reload(newConfig)
+			}
+			if err != nil {
+				fmt.Printf("error reloading config: %v\n", err)
+			}
+		}
+	}()
+
+	err = p.Run(ctx, reader)
+	if closeErr := closeExports(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// openExports creates the files to export intervals to, the returned func finishes and closes them
+func openExports(config manage.Config) ([]pipeline.Sink, func() error, error) {
+	sinks := []pipeline.Sink{}
+	closers := []io.Closer{} // in the order they're closed, exports before their files
+	closeAll := func() error {
+		var firstErr error
+		for _, c := range closers {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("error closing export file: %v", err)
+			}
+		}
+		return firstErr
+	}
+
+	if config.ExportCSV != "" {
+		f, err := os.Create(config.ExportCSV)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("error creating export file: %v", err)
+		}
+		closers = append(closers, f)
+		sinks = append(sinks, export.CSVSink(f))
+	}
+
+	if config.ExportParquet != "" {
+		f, err := os.Create(config.ExportParquet)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("error creating export file: %v", err)
+		}
+
+		sink, err := export.ParquetSink(f)
+		if err != nil {
+			f.Close()
+			closeAll()
+			return nil, nil, fmt.Errorf("error initializing parquet export: %v", err)
+		}
+		// the parquet footer is written on close if the pipeline stopped without a summary
+		closers = append(closers, sink, f)
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, closeAll, nil
+}
+
+// validateConfigCommand prints the merged settings as a config file, so it also shows where defaults apply
+func validateConfigCommand(name string, args []string) error {
+	_, flags, err := loadConfig(name, args, nil)
+	if err != nil {
+		return err
+	}
+
+	settings := map[string]string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name != manage.ConfigFlag {
+			settings[f.Name] = f.Value.String()
+		}
+	})
+
+	out, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("# the config is valid, these are the merged settings\n%s", out)
+	return nil
+}
+
+// checkFormatCommand shows how the columns and the first lines of the input are interpreted
+func checkFormatCommand(name string, args []string) error {
+	config, _, err := loadConfig(name, args, nil)
+	if err != nil {
+		return err
+	}
+
+	classifier, err := clients.InitClassifier(config.ClientGroups, config.ExcludeCIDRs)
+	if err != nil {
+		return err
+	}
+	lineFilter, err := filter.Compile(config.Filter)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(config.InputFilepath)
+	if err != nil {
+		return fmt.Errorf("error opening input file: %v", err)
+	}
+	defer file.Close()
+
+	if config.InputFormat == parsing.FormatCLF {
+		fmt.Println("clf lines are read into every column except latency, which they don't have")
+	} else {
+		header, err := csv.NewReader(file).Read()
+		if err == io.EOF {
+			fmt.Println("the input is empty")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error parsing header: %v", err)
+		}
+		printColumns(header)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	inputCh := make(chan parsing.WebServerLogData)
+	parseErrCh := make(chan error, 1)
+	go func() {
+		parseErrCh <- parsing.ParseWithChannel(file, config.InputFormat, inputCh)
+	}()
+
+	lines := 0
+	for data := range inputCh {
+		lines++
+		fmt.Printf("line %d: %s\n", lines, describeLine(&data, classifier, lineFilter))
+		if lines == checkFormatLines {
+			file.Close() // stops the parser, it's still reading ahead
+			for range inputCh {
+			}
+			return nil
+		}
+	}
+
+	if err := <-parseErrCh; err != nil {
+		return fmt.Errorf("error parsing line %d: %v", lines+1, err)
+	}
+	return nil
+}
+
+func printColumns(header []string) {
+	used := []string{}
+	ignored := []string{}
+	seen := map[string]bool{}
+	for _, column := range header {
+		seen[column] = true
+		if parsing.IsColumn(column) {
+			used = append(used, column)
+		} else {
+			ignored = append(ignored, column)
+		}
+	}
+
+	missing := []string{}
+	for _, column := range parsing.Columns {
+		if !seen[column] {
+			missing = append(missing, column)
+		}
+	}
+
+	fmt.Printf("columns used: %s\n", strings.Join(used, ", "))
+	if len(ignored) > 0 {
+		fmt.Printf("columns ignored: %s\n", strings.Join(ignored, ", "))
+	}
+	if len(missing) > 0 {
+		fmt.Printf("columns missing, read as empty: %s\n", strings.Join(missing, ", "))
+	}
+}
+
+func describeLine(data *parsing.WebServerLogData, classifier clients.Classifier, lineFilter filter.Filter) string {
+	group := classifier.Label(data.RemoteHost)
+	if group == "" {
+		group = "none"
+	}
+
+	counted := "counted"
+	if classifier.IsExcluded(data.RemoteHost) {
+		counted = "excluded by exclude-cidrs"
+	} else if !lineFilter.Match(data) {
+		counted = "not matched by filter"
+	}
+
+	return fmt.Sprintf(
+		"time %s, remotehost %s, method %s, section %s, status %d, bytes %d, latency %v, client group %s, %s",
+		time.Unix(int64(data.Date), 0), data.RemoteHost, data.RequestMethod(), data.RequestSection(),
+		data.Status, data.Bytes, time.Duration(data.Latency)*time.Microsecond, group, counted,
+	)
+}
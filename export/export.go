@@ -0,0 +1,107 @@
+/*Package export writes every interval as rows, one per section, so the aggregates can be
+ * loaded into a notebook or warehouse
+ */
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/pipeline"
+)
+
+// Row is the stats of a section for an interval. Start and End are unix times and inclusive, like the
+// interval output. Sections without hits in the interval don't have a row.
+type Row struct {
+	Start         uint64
+	End           uint64
+	Section       string
+	Hits          uint64
+	Bytes         uint64
+	StatusClasses [6]uint64 // 1 to 5 are hits for 1xx to 5xx, 0 is anything else, see `webstats.StatusClass`
+}
+
+// Rows returns the rows for an interval, ordered by section
+func Rows(sd *analytics.SectionData) []Row {
+	start := sd.Start()
+	sections := map[string]*Row{}
+	for _, slot := range sd.Window {
+		for k, hits := range slot.Sections {
+			row, ok := sections[k]
+			if !ok {
+				row = &Row{Start: start, End: sd.LatestTime, Section: k}
+				sections[k] = row
+			}
+
+			row.Hits += hits
+			traffic := slot.SectionTraffic[k]
+			row.Bytes += traffic.Bytes
+			for i, v := range traffic.StatusClasses {
+				row.StatusClasses[i] += v
+			}
+		}
+	}
+
+	rows := make([]Row, 0, len(sections))
+	for _, v := range sections {
+		rows = append(rows, *v)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Section < rows[j].Section })
+	return rows
+}
+
+// CSVHeader are the columns written by `CSVSink`
+var CSVHeader = []string{
+	"interval_start", "interval_end", "section", "hits", "bytes",
+	"status_1xx", "status_2xx", "status_3xx", "status_4xx", "status_5xx", "status_other",
+}
+
+type csvSink struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+// CSVSink writes the rows of every interval as csv, with times in RFC 3339. The output is flushed after
+// each interval, so it can be read while following a file.
+func CSVSink(writer io.Writer) pipeline.Sink {
+	return &csvSink{writer: csv.NewWriter(writer)}
+}
+
+func (cs *csvSink) Process(data analytics.ProcessAndOutputData) error {
+	if !cs.headerWritten {
+		// written on the first result, so the header is there even if there are no intervals
+		cs.writer.Write(CSVHeader)
+		cs.headerWritten = true
+	}
+
+	if sd, ok := data.(*analytics.SectionData); ok {
+		for _, row := range Rows(sd) {
+			cs.writer.Write(csvRecord(row))
+		}
+	}
+
+	cs.writer.Flush()
+	return cs.writer.Error()
+}
+
+func csvRecord(row Row) []string {
+	record := []string{
+		formatTime(row.Start),
+		formatTime(row.End),
+		row.Section,
+		strconv.FormatUint(row.Hits, 10),
+		strconv.FormatUint(row.Bytes, 10),
+	}
+	for i := 1; i < len(row.StatusClasses); i++ {
+		record = append(record, strconv.FormatUint(row.StatusClasses[i], 10))
+	}
+	return append(record, strconv.FormatUint(row.StatusClasses[0], 10))
+}
+
+func formatTime(t uint64) string {
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,143 @@
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/export"
+	"github.com/hardboiled/apache-log-parser/manage"
+	"github.com/hardboiled/apache-log-parser/pipeline"
+	"github.com/hardboiled/apache-log-parser/webstats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestExport(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Export Suite")
+}
+
+// parquetRow has the columns the parquet file is expected to have
+type parquetRow struct {
+	IntervalStart int64  `parquet:"name=interval_start, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	IntervalEnd   int64  `parquet:"name=interval_end, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Section       string `parquet:"name=section, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Hits          int64  `parquet:"name=hits, type=INT64"`
+	Bytes         int64  `parquet:"name=bytes, type=INT64"`
+	Status1xx     int64  `parquet:"name=status_1xx, type=INT64"`
+	Status2xx     int64  `parquet:"name=status_2xx, type=INT64"`
+	Status3xx     int64  `parquet:"name=status_3xx, type=INT64"`
+	Status4xx     int64  `parquet:"name=status_4xx, type=INT64"`
+	Status5xx     int64  `parquet:"name=status_5xx, type=INT64"`
+	StatusOther   int64  `parquet:"name=status_other, type=INT64"`
+}
+
+// failingSink fails on the first interval, like a sink writing to a full disk
+type failingSink struct{}
+
+func (failingSink) Process(data analytics.ProcessAndOutputData) error {
+	if _, ok := data.(*analytics.SectionData); ok {
+		return errors.New("disk full")
+	}
+	return nil
+}
+
+func readParquet(data []byte) []parquetRow {
+	file, err := buffer.NewBufferFile(data)
+	Expect(err).To(BeNil())
+	pr, err := reader.NewParquetReader(file, new(parquetRow), 1)
+	Expect(err).To(BeNil())
+	defer pr.ReadStop()
+
+	rows := make([]parquetRow, pr.GetNumRows())
+	Expect(pr.Read(&rows)).To(BeNil())
+	return rows
+}
+
+var _ = Describe("Export", func() {
+	startTime := uint64(1549573860)
+	interval := &analytics.SectionData{
+		LatestTime: startTime + 1,
+		Window: []webstats.WindowEntry{
+			{
+				Sections: map[string]uint64{"/report": 1, "/api": 2},
+				SectionTraffic: map[string]webstats.SectionTraffic{
+					"/report": {Bytes: 10, StatusClasses: [6]uint64{0, 0, 1, 0, 0, 0}},
+					"/api":    {Bytes: 20, StatusClasses: [6]uint64{0, 0, 1, 0, 1, 0}},
+				},
+			},
+			{
+				Sections:       map[string]uint64{"/api": 1},
+				SectionTraffic: map[string]webstats.SectionTraffic{"/api": {Bytes: 5, StatusClasses: [6]uint64{0, 0, 0, 0, 0, 1}}},
+			},
+		},
+	}
+
+	It("totals each section over the interval", func() {
+		Expect(export.Rows(interval)).To(Equal([]export.Row{
+			{Start: startTime, End: startTime + 1, Section: "/api", Hits: 3, Bytes: 25, StatusClasses: [6]uint64{0, 0, 1, 0, 1, 1}},
+			{Start: startTime, End: startTime + 1, Section: "/report", Hits: 1, Bytes: 10, StatusClasses: [6]uint64{0, 0, 1, 0, 0, 0}},
+		}))
+	})
+
+	It("writes csv rows for intervals only", func() {
+		var buf bytes.Buffer
+		sink := export.CSVSink(&buf)
+		Expect(sink.Process(interval)).To(BeNil())
+		Expect(sink.Process(analytics.TotalHitsAlarm{Flag: true})).To(BeNil())
+		Expect(sink.Process(analytics.Summary{})).To(BeNil())
+
+		Expect(strings.Split(buf.String(), "\n")).To(Equal([]string{
+			strings.Join(export.CSVHeader, ","),
+			"2019-02-07T21:11:00Z,2019-02-07T21:11:01Z,/api,3,25,0,1,0,1,1,0",
+			"2019-02-07T21:11:00Z,2019-02-07T21:11:01Z,/report,1,10,0,1,0,0,0,0",
+			"",
+		}))
+	})
+
+	It("writes the header when there are no intervals", func() {
+		var buf bytes.Buffer
+		Expect(export.CSVSink(&buf).Process(analytics.Summary{})).To(BeNil())
+		Expect(buf.String()).To(Equal(strings.Join(export.CSVHeader, ",") + "\n"))
+	})
+
+	It("writes a parquet file that's complete after the summary", func() {
+		var buf bytes.Buffer
+		sink, err := export.ParquetSink(&buf)
+		Expect(err).To(BeNil())
+		Expect(sink.Process(interval)).To(BeNil())
+		Expect(sink.Process(analytics.Summary{})).To(BeNil())
+
+		Expect(sink.Close()).To(BeNil()) // already finished by the summary
+		Expect(readParquet(buf.Bytes())).To(Equal([]parquetRow{
+			{int64(startTime) * 1000, int64(startTime+1) * 1000, "/api", 3, 25, 0, 1, 0, 1, 1, 0},
+			{int64(startTime) * 1000, int64(startTime+1) * 1000, "/report", 1, 10, 0, 1, 0, 0, 0, 0},
+		}))
+	})
+
+	It("finishes the parquet file on close when the pipeline stops without a summary", func() {
+		lines := []string{`"remotehost","rfc931","authuser","date","request","status","bytes"`}
+		for i := uint64(0); i < 30; i++ {
+			lines = append(lines, fmt.Sprintf(`"10.0.0.1","-","apache",%d,"GET /api/user HTTP/1.0",200,100`, startTime+i))
+		}
+		config := manage.Config{Interval: 10, ReorderTolerance: analytics.BufferForOverlappingLogTimes, WindowSize: 240, AlarmThreshold: 10}
+
+		var buf bytes.Buffer
+		sink, err := export.ParquetSink(&buf)
+		Expect(err).To(BeNil())
+		err = pipeline.Run(context.Background(), strings.NewReader(strings.Join(lines, "\n")+"\n"), config, sink, failingSink{})
+		Expect(err).To(MatchError(ContainSubstring("disk full")))
+
+		Expect(sink.Close()).To(BeNil())
+		rows := readParquet(buf.Bytes())
+		Expect(rows).To(HaveLen(1)) // the interval the parquet sink got before the other one failed
+		Expect(rows[0].Section).To(Equal("/api"))
+	})
+})
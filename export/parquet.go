@@ -0,0 +1,89 @@
+package export
+
+import (
+	"io"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRow is a Row with the same columns as the csv, times are timestamps in milliseconds
+type parquetRow struct {
+	IntervalStart int64  `parquet:"name=interval_start, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	IntervalEnd   int64  `parquet:"name=interval_end, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Section       string `parquet:"name=section, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Hits          int64  `parquet:"name=hits, type=INT64"`
+	Bytes         int64  `parquet:"name=bytes, type=INT64"`
+	Status1xx     int64  `parquet:"name=status_1xx, type=INT64"`
+	Status2xx     int64  `parquet:"name=status_2xx, type=INT64"`
+	Status3xx     int64  `parquet:"name=status_3xx, type=INT64"`
+	Status4xx     int64  `parquet:"name=status_4xx, type=INT64"`
+	Status5xx     int64  `parquet:"name=status_5xx, type=INT64"`
+	StatusOther   int64  `parquet:"name=status_other, type=INT64"`
+}
+
+// ParquetExport is the sink returned by `ParquetSink`
+type ParquetExport struct {
+	writer   *writer.ParquetWriter
+	finished bool
+}
+
+// ParquetSink writes the rows of every interval as parquet. Parquet files can only be read once
+// they're complete, so the file is finished when the pipeline sends its summary, or when the export
+// is closed, e.g. if the pipeline stopped on an error before sending a summary.
+func ParquetSink(w io.Writer) (*ParquetExport, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), 1)
+	if err != nil {
+		return nil, err
+	}
+	return &ParquetExport{writer: pw}, nil
+}
+
+// Process writes the rows of an interval, and finishes the file on the summary
+func (pe *ParquetExport) Process(data analytics.ProcessAndOutputData) error {
+	if pe.finished {
+		return nil
+	}
+
+	switch v := data.(type) {
+	case *analytics.SectionData:
+		for _, row := range Rows(v) {
+			if err := pe.writer.Write(newParquetRow(row)); err != nil {
+				return err
+			}
+		}
+	case analytics.Summary:
+		return pe.finish()
+	}
+
+	return nil
+}
+
+// Close finishes the file if the summary didn't, it doesn't close the underlying writer
+func (pe *ParquetExport) Close() error {
+	if pe.finished {
+		return nil
+	}
+	return pe.finish()
+}
+
+func (pe *ParquetExport) finish() error {
+	pe.finished = true
+	return pe.writer.WriteStop()
+}
+
+func newParquetRow(row Row) parquetRow {
+	return parquetRow{
+		IntervalStart: int64(row.Start) * 1000,
+		IntervalEnd:   int64(row.End) * 1000,
+		Section:       row.Section,
+		Hits:          int64(row.Hits),
+		Bytes:         int64(row.Bytes),
+		Status1xx:     int64(row.StatusClasses[1]),
+		Status2xx:     int64(row.StatusClasses[2]),
+		Status3xx:     int64(row.StatusClasses[3]),
+		Status4xx:     int64(row.StatusClasses[4]),
+		Status5xx:     int64(row.StatusClasses[5]),
+		StatusOther:   int64(row.StatusClasses[0]),
+	}
+}
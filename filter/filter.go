@@ -0,0 +1,255 @@
+/*Package filter evaluates user supplied expressions against parsed log lines,
+ * e.g. `status >= 500 && section == "/api"` or `method != "HEAD"`
+ */
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hardboiled/apache-log-parser/parsing"
+)
+
+var numberFields = map[string]func(ld *parsing.WebServerLogData) uint64{
+	"date":    func(ld *parsing.WebServerLogData) uint64 { return ld.Date },
+	"status":  func(ld *parsing.WebServerLogData) uint64 { return ld.Status },
+	"bytes":   func(ld *parsing.WebServerLogData) uint64 { return ld.Bytes },
+	"latency": func(ld *parsing.WebServerLogData) uint64 { return ld.Latency },
+}
+
+var stringFields = map[string]func(ld *parsing.WebServerLogData) string{
+	"remotehost": func(ld *parsing.WebServerLogData) string { return ld.RemoteHost },
+	"rfc931":     func(ld *parsing.WebServerLogData) string { return ld.Rfc931 },
+	"authuser":   func(ld *parsing.WebServerLogData) string { return ld.AuthUser },
+	"request":    func(ld *parsing.WebServerLogData) string { return ld.Request },
+	"method":     func(ld *parsing.WebServerLogData) string { return ld.RequestMethod() },
+	"section":    func(ld *parsing.WebServerLogData) string { return ld.RequestSection() },
+}
+
+// Filter is a compiled expression, safe to use from multiple go routines
+type Filter struct {
+	expression string
+	root       node // nil matches every line
+}
+
+// Compile parses the expression. Comparisons are joined with `&&`, `||`, `!` and parentheses,
+// numeric fields support ==, !=, <, <=, >, >= and string fields support == and !=.
+// An empty expression matches every line.
+func Compile(expression string) (Filter, error) {
+	p := parser{lexer: lexer{input: expression}}
+	if err := p.advance(); err != nil {
+		return Filter{}, p.wrap(err)
+	}
+
+	if p.tok.kind == tokEOF {
+		return Filter{expression: expression}, nil
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return Filter{}, p.wrap(err)
+	}
+
+	if p.tok.kind != tokEOF {
+		return Filter{}, p.wrap(fmt.Errorf("unexpected %q at position %d", p.tok.text, p.tok.pos))
+	}
+
+	return Filter{expression: expression, root: root}, nil
+}
+
+// Match returns true if the line satisfies the expression
+func (f *Filter) Match(ld *parsing.WebServerLogData) bool {
+	if f.root == nil {
+		return true
+	}
+
+	return f.root.eval(ld)
+}
+
+// String returns the original expression
+func (f *Filter) String() string {
+	return f.expression
+}
+
+type node interface {
+	eval(ld *parsing.WebServerLogData) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(ld *parsing.WebServerLogData) bool { return n.left.eval(ld) && n.right.eval(ld) }
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(ld *parsing.WebServerLogData) bool { return n.left.eval(ld) || n.right.eval(ld) }
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(ld *parsing.WebServerLogData) bool { return !n.operand.eval(ld) }
+
+type numberComparison struct {
+	field func(ld *parsing.WebServerLogData) uint64
+	op    string
+	value uint64
+}
+
+func (n numberComparison) eval(ld *parsing.WebServerLogData) bool {
+	v := n.field(ld)
+	switch n.op {
+	case "==":
+		return v == n.value
+	case "!=":
+		return v != n.value
+	case "<":
+		return v < n.value
+	case "<=":
+		return v <= n.value
+	case ">":
+		return v > n.value
+	default: // ">="
+		return v >= n.value
+	}
+}
+
+type stringComparison struct {
+	field func(ld *parsing.WebServerLogData) string
+	equal bool
+	value string
+}
+
+func (n stringComparison) eval(ld *parsing.WebServerLogData) bool {
+	return (n.field(ld) == n.value) == n.equal
+}
+
+type parser struct {
+	lexer lexer
+	tok   token
+}
+
+func (p *parser) wrap(err error) error {
+	return fmt.Errorf("invalid filter %q: %v", p.lexer.input, err)
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind == tokOr {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind == tokAnd {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	switch p.tok.kind {
+	case tokNot:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	case tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at position %d", p.tok.pos)
+		}
+		return inner, p.advance()
+	default:
+		return p.parseComparison()
+	}
+}
+
+func (p *parser) parseComparison() (node, error) {
+	if p.tok.kind != tokIdent {
+		return nil, fmt.Errorf("expected a field name at position %d", p.tok.pos)
+	}
+	name := strings.ToLower(p.tok.text)
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokCompare {
+		return nil, fmt.Errorf("expected a comparison after %s at position %d", name, p.tok.pos)
+	}
+	op := p.tok.text
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	value := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if field, ok := numberFields[name]; ok {
+		if value.kind != tokNumber {
+			return nil, fmt.Errorf("%s must be compared to a number", name)
+		}
+		n, err := strconv.ParseUint(value.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s: %v", value.text, err)
+		}
+		return numberComparison{field: field, op: op, value: n}, nil
+	}
+
+	if field, ok := stringFields[name]; ok {
+		if value.kind != tokString {
+			return nil, fmt.Errorf("%s must be compared to a quoted string", name)
+		}
+		if op != "==" && op != "!=" {
+			return nil, fmt.Errorf("%s only supports == and !=", name)
+		}
+		return stringComparison{field: field, equal: op == "==", value: value.text}, nil
+	}
+
+	return nil, fmt.Errorf("unknown field %s", name)
+}
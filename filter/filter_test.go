@@ -0,0 +1,69 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/hardboiled/apache-log-parser/filter"
+	"github.com/hardboiled/apache-log-parser/parsing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFilter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Filter Suite")
+}
+
+var _ = Describe("Filter", func() {
+	var apiError, helpOk, apiHead parsing.WebServerLogData
+
+	BeforeEach(func() {
+		apiError = parsing.WebServerLogData{RemoteHost: "10.0.0.1", Request: "GET /api/user HTTP/1.0", Status: 503, Bytes: 10}
+		helpOk = parsing.WebServerLogData{RemoteHost: "10.0.0.2", Request: "POST /help HTTP/1.0", Status: 200, Bytes: 1234}
+		apiHead = parsing.WebServerLogData{RemoteHost: "10.0.0.3", Request: "HEAD /api HTTP/1.0", Status: 200, Bytes: 0}
+	})
+
+	It("matches everything for an empty expression", func() {
+		f, err := filter.Compile("  ")
+		Expect(err).To(BeNil())
+		Expect(f.Match(&apiError)).To(Equal(true))
+	})
+
+	It("evaluates comparisons joined by && and ||", func() {
+		f, err := filter.Compile(`status >= 500 && section == "/api"`)
+		Expect(err).To(BeNil())
+		Expect(f.Match(&apiError)).To(Equal(true))
+		Expect(f.Match(&helpOk)).To(Equal(false))
+		Expect(f.Match(&apiHead)).To(Equal(false))
+
+		f, err = filter.Compile(`method == "POST" || bytes < 100 && status == 200`)
+		Expect(err).To(BeNil())
+		Expect(f.Match(&apiError)).To(Equal(false))
+		Expect(f.Match(&helpOk)).To(Equal(true))
+		Expect(f.Match(&apiHead)).To(Equal(true))
+	})
+
+	It("supports negation and parentheses", func() {
+		f, err := filter.Compile(`method != "HEAD" && !(remotehost == "10.0.0.2" || status < 300)`)
+		Expect(err).To(BeNil())
+		Expect(f.Match(&apiError)).To(Equal(true))
+		Expect(f.Match(&helpOk)).To(Equal(false))
+		Expect(f.Match(&apiHead)).To(Equal(false))
+	})
+
+	It("rejects invalid expressions", func() {
+		for _, expr := range []string{
+			`status >= "500"`,
+			`section > "/api"`,
+			`section == /api`,
+			`unknown == 1`,
+			`status >= 500 &&`,
+			`(status >= 500`,
+			`section == "/api`,
+			`status = 500`,
+		} {
+			_, err := filter.Compile(expr)
+			Expect(err).ToNot(BeNil(), expr)
+		}
+	})
+})
@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokCompare
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	rest := l.input[l.pos:]
+	for _, op := range []string{"&&", "||", "==", "!=", "<=", ">="} {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len(op)
+			switch op {
+			case "&&":
+				return token{tokAnd, op, start}, nil
+			case "||":
+				return token{tokOr, op, start}, nil
+			default:
+				return token{tokCompare, op, start}, nil
+			}
+		}
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c == '<' || c == '>':
+		l.pos++
+		return token{tokCompare, string(c), start}, nil
+	case c == '!':
+		l.pos++
+		return token{tokNot, "!", start}, nil
+	case c == '(':
+		l.pos++
+		return token{tokLParen, "(", start}, nil
+	case c == ')':
+		l.pos++
+		return token{tokRParen, ")", start}, nil
+	case c == '"':
+		return l.quoted()
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.input) && l.input[l.pos] >= '0' && l.input[l.pos] <= '9' {
+			l.pos++
+		}
+		return token{tokNumber, l.input[start:l.pos], start}, nil
+	case unicode.IsLetter(rune(c)):
+		for l.pos < len(l.input) && (unicode.IsLetter(rune(l.input[l.pos])) || unicode.IsDigit(rune(l.input[l.pos]))) {
+			l.pos++
+		}
+		return token{tokIdent, l.input[start:l.pos], start}, nil
+	}
+
+	return token{}, fmt.Errorf("unexpected %q at position %d", c, start)
+}
+
+func (l *lexer) quoted() (token, error) {
+	start := l.pos
+	l.pos++ // opening quote
+	for l.pos < len(l.input) {
+		switch l.input[l.pos] {
+		case '\\':
+			l.pos += 2
+		case '"':
+			l.pos++
+			text, err := strconv.Unquote(l.input[start:l.pos])
+			if err != nil {
+				return token{}, fmt.Errorf("invalid string at position %d: %v", start, err)
+			}
+			return token{tokString, text, start}, nil
+		default:
+			l.pos++
+		}
+	}
+
+	return token{}, fmt.Errorf("unterminated string at position %d", start)
+}
@@ -3,9 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"os"
-	"sync"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/hardboiled/apache-log-parser/analytics"
 	"github.com/hardboiled/apache-log-parser/manage"
@@ -15,105 +16,125 @@ import (
 
 const (
 	defaultInterval       = 10
-	defaultWindowSize     = webstats.MinWindowSize * 2
+	defaultWindowSize     = webstats.DefaultWindowSize
 	defaultAlarmThreshold = 10
+	defaultSlowPercent    = 1.0
+	defaultTopClients     = 3
+	defaultRateLimitSecs  = 10
 	defaultInputFilepath  = "./input_files/sample_csv.txt"
+	defaultStateSaveSecs  = 60
 )
 
-func getFlags() (manage.Config, error) {
-	interval := flag.Uint("interval", defaultInterval, "integer in seconds")
-	windowSize := flag.Uint("window-retention", defaultWindowSize, fmt.Sprintf("integer in seconds (min %d)", defaultWindowSize))
-	alarmThreshold := flag.Uint("alarm-threshold", defaultAlarmThreshold, "triggers alarm on request/per second over 2 mins")
-	inputFilepath := flag.String("input-filepath", defaultInputFilepath, "file path to read in")
-
-	flag.Parse()
-
-	return manage.InitConfig(*interval, *windowSize, *alarmThreshold, *inputFilepath)
+// command is a subcommand, e.g. `apache-log-parser replay -input-filepath=...`
+type command struct {
+	name        string
+	description string
+	run         func(name string, args []string) error
 }
 
-func setupBuffers(config manage.Config) (io.ReadCloser, chan parsing.WebServerLogData, chan analytics.ProcessAndOutputData, error) {
-	reader, err := os.Open(config.InputFilepath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("error opening input file: %v", err)
+// commands are the subcommands. Running without one works the same as before they were added.
+var commands []command
+
+func init() {
+	// set in init since the usage the commands print refers back to this list
+	commands = []command{
+		{"run", "live agent, follows the input file and prints intervals and alarms as they happen", runCommand},
+		{"replay", "reads a historic file through the same pipeline, optionally paced with -replay-speed", replayCommand},
+		{"report", "reads a whole file and prints totals, top sections, statuses, the busiest times and alarm episodes", reportCommand},
+		{"validate-config", "checks the merged flags, environment and config file, and prints the settings", validateConfigCommand},
+		{"check-format", "parses the first lines of the input file and shows how they're interpreted", checkFormatCommand},
 	}
-
-	inputCh := make(chan parsing.WebServerLogData, 100)
-	outputCh := make(chan analytics.ProcessAndOutputData)
-
-	return reader, inputCh, outputCh, nil
 }
 
-func main() {
-	config, err := getFlags()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// loadConfig merges the flags in args with the environment and config file. It's called again on SIGHUP,
+// so the flags are defined on a new FlagSet every time. adjust, if not nil, can override options that a
+// subcommand decides, e.g. `run` always follows the input. The FlagSet is returned with the merged values.
+func loadConfig(name string, args []string, adjust func(*manage.Options)) (manage.Config, *flag.FlagSet, error) {
+	o := manage.Options{}
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() { usage(flags) }
+	o.Interval = manage.Seconds(defaultInterval)
+	flags.Var(&o.Interval, "interval", "duration like 10s or 1m, plain integers are seconds")
+	o.ReorderTolerance = manage.Seconds(uint(analytics.BufferForOverlappingLogTimes))
+	flags.Var(&o.ReorderTolerance, "reorder-tolerance", "duration, how long to wait for out of order lines before printing an interval")
+	o.WindowSize = manage.Seconds(defaultWindowSize)
+	flags.Var(&o.WindowSize, "window-retention", fmt.Sprintf("duration between %v and %v", webstats.MinWindowSize*time.Second, webstats.MaxWindowRetention))
+	flags.StringVar(&o.Rollups, "rollups", webstats.DefaultRollups, "resolution:retention pairs of coarser stats kept for longer than window-retention, intervals too long for the window are read from them (\"\" keeps none)")
+	flags.UintVar(&o.AlarmThreshold, "alarm-threshold", defaultAlarmThreshold, "triggers alarm on request/per second over 2 mins")
+	o.LatencyThreshold = manage.Milliseconds(0)
+	flags.Var(&o.LatencyThreshold, "latency-threshold", "duration like 500ms, plain integers are milliseconds, requests slower than this count as slow (0 disables the latency alarm)")
+	flags.Float64Var(&o.LatencySlowPercent, "latency-slow-percent", defaultSlowPercent, "triggers latency alarm when more than this percent of requests over 2 mins are slow (1 = p99)")
+	flags.UintVar(&o.TopClients, "top-clients", defaultTopClients, "number of remote hosts with the most hits to print for each interval")
+	flags.UintVar(&o.MaxSections, "max-sections", webstats.DefaultMaxSections, "distinct sections to keep stats for, later ones are counted as \""+webstats.OtherSection+"\" so scanners can't use up memory (0 is unlimited)")
+	flags.UintVar(&o.RateLimitRequests, "rate-limit-requests", 0, "alerts when a single client sends more than this many requests within rate-limit-seconds (0 disables)")
+	o.RateLimitSeconds = manage.Seconds(defaultRateLimitSecs)
+	flags.Var(&o.RateLimitSeconds, "rate-limit-seconds", "duration, period that rate-limit-requests applies to")
+	flags.StringVar(&o.ClientGroups, "client-groups", "", "labels clients by CIDR, e.g. \"internal=10.0.0.0/8;office=192.168.1.0/24,192.168.2.0/24\"")
+	flags.StringVar(&o.ExcludeCIDRs, "exclude-cidrs", "", "comma separated CIDRs whose traffic is left out of stats, e.g. health checkers")
+	flags.StringVar(&o.Filter, "filter", "", "only count lines matching this expression, e.g. 'status >= 500 && section == \"/api\"'")
+	flags.StringVar(&o.LateData, "late-data", "drop", "drop or clamp lines older than window-retention, clamp counts them at the oldest second still retained")
+	flags.StringVar(&o.InputFilepath, "input-filepath", defaultInputFilepath, "file path to read in")
+	flags.StringVar(&o.InputFormat, "input-format", parsing.FormatCSV, "csv with a header naming the columns, or clf for apache's common or combined log format, which has no latency")
+	flags.StringVar(&o.ReplaySpeed, "replay-speed", "max", "replays the input paced by its log times, e.g. 1x, 10x, or max to read it as fast as possible")
+	flags.BoolVar(&o.Follow, "follow", false, "live mode, tails the input file from its end and prints intervals and alarms based on the wall clock")
+	flags.StringVar(&o.StateFilepath, "state-filepath", "", "file to save stats and alarm state to, so a restarted agent resumes where it left off (requires follow)")
+	o.StateSaveInterval = manage.Seconds(defaultStateSaveSecs)
+	flags.Var(&o.StateSaveInterval, "state-save-interval", "duration, how often state is saved, it's also saved on shutdown")
+	flags.StringVar(&o.ReportFormat, "report-format", "text", "text or html, html is a self-contained page with charts, e.g. to share after an incident (report command only)")
+	flags.StringVar(&o.ExportCSV, "export-csv", "", "file to write every interval to as csv rows, one per section with hits, bytes and status counts")
+	flags.StringVar(&o.ExportParquet, "export-parquet", "", "file to write the same rows as -export-csv to as parquet, it's complete once the input ends or the agent stops")
+	flags.String(manage.ConfigFlag, "", "yaml file with settings named after these flags, environment variables like "+manage.EnvName("alarm-threshold")+" override it, and flags override both")
+
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return manage.Config{}, flags, fmt.Errorf("unexpected argument %q, see -h for usage", flags.Arg(0))
 	}
 
-	reader, inputCh, outputCh, err := setupBuffers(config)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	o.SourceErrors = manage.MergeSources(flags, os.LookupEnv)
+	if adjust != nil {
+		adjust(&o)
 	}
 
-	// setup go routines and channels
-	var wg sync.WaitGroup
-	defer reader.Close()
-	defer close(outputCh)
-
-	// Note: `ParseWebServerLogDataWithChannel` closes the inputCh when finished
-	go parsing.ParseWebServerLogDataWithChannel(reader, inputCh)
-	go analytics.ProcessStats(outputCh, os.Stdout, &wg)
+	config, err := manage.InitConfig(o)
+	return config, flags, err
+}
 
-	// read in first entry to initialize
-	firstEntry := <-inputCh
-	webStats, err := webstats.InitWebStats(config.WindowSize, config.AlarmThreshold, firstEntry.Date)
-	if err != nil {
-		fmt.Printf("error initializing webstats: %v\n", err)
-		os.Exit(1)
-	}
-	webStats.AddEntry(firstEntry.RequestSection(), firstEntry.Date)
-	scheduleInterval, err := analytics.InitScheduleInterval(firstEntry.Date, config.Interval)
-	if err != nil {
-		fmt.Printf("error initializing scheduleInterval: %v\n", err)
-		os.Exit(1)
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n", flags.Name())
+	if flags.Name() == filepath.Base(os.Args[0]) {
+		fmt.Fprintf(out, "       %s <command> [flags]\n\nCommands:\n", flags.Name())
+		for _, cmd := range commands {
+			fmt.Fprintf(out, "  %-16s %s\n", cmd.name, cmd.description)
+		}
 	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flags.PrintDefaults()
+}
 
-	// main loop
-	for data := range inputCh {
-		// Check to print interval
-		if scheduleInterval.ReadyToProcess(data.Date) {
-			wg.Add(1)
-			outputCh <- &analytics.SectionData{
-				LatestTime: scheduleInterval.TimeToProcess(),
-				Window:     webStats.GetWindowForRange(scheduleInterval.TimeToProcess(), scheduleInterval.SecondsAgo()),
+func main() {
+	name := filepath.Base(os.Args[0])
+	args := os.Args[1:]
+	run := legacyCommand
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		run = nil
+		for _, cmd := range commands {
+			if cmd.name == args[0] {
+				run = cmd.run
+				name += " " + cmd.name
+				args = args[1:]
+				break
 			}
-			scheduleInterval.MarkAsProcessed()
 		}
-
-		// Compare alarm state from previous entry, if different, print alarm status
-		lastAlarm := webStats.HasTotalTrafficAlarm()
-		webStats.AddEntry(data.RequestSection(), data.Date)
-		curAlarm := webStats.HasTotalTrafficAlarm()
-		if lastAlarm != curAlarm {
-			wg.Add(1)
-			outputCh <- analytics.TotalHitsAlarm{Flag: curAlarm, Hits: webStats.TotalHitsForLast2Min(), CurrentTime: webStats.LatestTime()}
+		if run == nil {
+			fmt.Printf("unknown command %q, see -h for usage\n", args[0])
+			os.Exit(2)
 		}
 	}
 
-	// Flush remaining results
-	if scheduleInterval.LastTimeProcessed() < webStats.LatestTime() {
-		numSecondsLeft := webStats.LatestTime() - scheduleInterval.LastTimeProcessed()
-		if numSecondsLeft > config.Interval {
-			numSecondsLeft = config.Interval // if lastTimeProcessed was greater than the interval, only process the last interval
-		}
-
-		wg.Add(1)
-		outputCh <- &analytics.SectionData{
-			LatestTime: webStats.LatestTime(),
-			Window:     webStats.GetWindowForRange(webStats.LatestTime(), numSecondsLeft),
-		}
+	if err := run(name, args); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-
-	wg.Wait()
 }
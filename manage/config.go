@@ -4,42 +4,219 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/hardboiled/apache-log-parser/clients"
+	"github.com/hardboiled/apache-log-parser/filter"
+	"github.com/hardboiled/apache-log-parser/parsing"
 	"github.com/hardboiled/apache-log-parser/webstats"
 )
 
 type Config struct {
-	Interval       uint64 //
-	WindowSize     uint
-	AlarmThreshold uint
-	InputFilepath  string
+	Interval           uint64 //
+	WindowSize         uint
+	Rollups            []webstats.Rollup // coarser resolutions kept for longer than the window
+	AlarmThreshold     uint
+	LatencyThreshold   uint // milliseconds, 0 disables the latency alarm
+	LatencySlowPercent float64
+	TopClients         uint
+	MaxSections        uint // distinct sections kept before new ones are counted as other, 0 is unlimited
+	RateLimitRequests  uint // 0 disables rate limit checks
+	RateLimitSeconds   uint
+	ClientGroups       map[string][]string // label -> CIDRs
+	ExcludeCIDRs       []string
+	Filter             string // expression lines must match to be counted, "" counts every line
+	LateData           string // "drop" or "clamp" lines older than the window
+	ReorderTolerance   uint64 // seconds to wait for out of order lines before printing an interval
+	InputFilepath      string
+	InputFormat        string  // "csv" or "clf", see `parsing.NewParser`
+	Follow             bool    // tail the input file, intervals and alarms are driven by the wall clock
+	ReplaySpeed        float64 // multiple of log time to replay the input at, 0 reads it as fast as possible
+	StateFilepath      string  // file to save progress to so a restart resumes from it, "" disables
+	StateSaveInterval  uint    // seconds between saves, state is also saved on shutdown
+	ReportFormat       string  // "text" or "html", how the report command prints its report
+	ExportCSV          string  // file to write interval rows to as csv, "" disables
+	ExportParquet      string  // file to write interval rows to as parquet, "" disables
 }
 
-func InitConfig(interval, windowSize, alarmThreshold uint, inputFilepath string) (Config, error) {
-	errStrings := []string{}
+// Options are the settings as they're given on the command line, in the config file or in the environment.
+// Each one has the same name everywhere, see `MergeSources`.
+type Options struct {
+	Interval           Duration
+	ReorderTolerance   Duration
+	WindowSize         Duration
+	Rollups            string // "resolution:retention,resolution:retention", see `webstats.ParseRollups`
+	AlarmThreshold     uint
+	LatencyThreshold   Duration
+	LatencySlowPercent float64
+	TopClients         uint
+	MaxSections        uint
+	RateLimitRequests  uint
+	RateLimitSeconds   Duration
+	ClientGroups       string // "label=cidr,cidr;label2=cidr"
+	ExcludeCIDRs       string // comma separated
+	Filter             string
+	LateData           string
+	InputFilepath      string
+	InputFormat        string
+	Follow             bool
+	ReplaySpeed        string
+	StateFilepath      string
+	StateSaveInterval  Duration
+	ReportFormat       string
+	ExportCSV          string
+	ExportParquet      string
+	SourceErrors       []string // settings that couldn't be read or applied by `MergeSources`
+}
+
+// parseClientGroups parses groups in the form "label=cidr,cidr;label2=cidr". A label that's given more
+// than once gets the CIDRs of each.
+func parseClientGroups(clientGroups string) (map[string][]string, error) {
+	groups := map[string][]string{}
+	for _, group := range strings.Split(clientGroups, ";") {
+		if strings.TrimSpace(group) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(group, "=", 2)
+		label := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || label == "" {
+			return nil, fmt.Errorf("client group %q must be in the form label=cidr,cidr", group)
+		}
+
+		cidrs := splitList(parts[1])
+		if len(cidrs) == 0 {
+			return nil, fmt.Errorf("client group %q has no CIDRs", label)
+		}
+		groups[label] = append(groups[label], cidrs...)
+	}
+
+	return groups, nil
+}
+
+// parseReplaySpeed parses speeds like "1x", "10x" or "max", max returns 0
+func parseReplaySpeed(replaySpeed string) (float64, error) {
+	replaySpeed = strings.TrimSpace(replaySpeed)
+	if replaySpeed == "" || replaySpeed == "max" {
+		return 0, nil
+	}
+
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(replaySpeed, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("replay-speed %q must be max or a positive multiple like 10x", replaySpeed)
+	}
+
+	return speed, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(list string) []string {
+	result := []string{}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+// InitConfig validates the options once they've been merged from every source, and converts them to a Config.
+// The errors include the SourceErrors, so every problem with the settings is reported at once.
+func InitConfig(o Options) (Config, error) {
+	errStrings := append([]string{}, o.SourceErrors...)
+	interval := o.Interval.inUnits("interval", time.Second, &errStrings)
+	reorderTolerance := o.ReorderTolerance.inUnits("reorder-tolerance", time.Second, &errStrings)
+	windowSize := o.WindowSize.inUnits("window-retention", time.Second, &errStrings)
+	latencyThreshold := o.LatencyThreshold.inUnits("latency-threshold", time.Millisecond, &errStrings)
+	rateLimitSeconds := o.RateLimitSeconds.inUnits("rate-limit-seconds", time.Second, &errStrings)
+	stateSaveInterval := o.StateSaveInterval.inUnits("state-save-interval", time.Second, &errStrings)
+
 	if interval < 1 {
-		errStrings = append(errStrings, "interval cannot be < 1")
+		errStrings = append(errStrings, "interval cannot be < 1s")
 	}
 
 	if windowSize < webstats.MinWindowSize {
-		errStrings = append(errStrings, fmt.Sprintf("window-retention cannot be < %d", webstats.MinWindowSize))
+		errStrings = append(errStrings, fmt.Sprintf("window-retention of %s cannot be < %s", bothForms(windowSize), bothForms(webstats.MinWindowSize)))
 	}
 
 	if windowSize > webstats.MaxWindowSize {
-		errStrings = append(errStrings, fmt.Sprintf("window-retention cannot be > %d bytes", webstats.MaxWindowSize))
+		errStrings = append(errStrings, fmt.Sprintf("window-retention of %s cannot be > %s", bothForms(windowSize), bothForms(webstats.MaxWindowSize)))
+	}
+
+	rollups, rollupsErr := webstats.ParseRollups(o.Rollups)
+	if rollupsErr != nil {
+		errStrings = append(errStrings, rollupsErr.Error())
 	}
 
-	if alarmThreshold < 1 {
+	if o.AlarmThreshold < 1 {
 		errStrings = append(errStrings, "alarmThreshold cannot be < 1")
 	}
 
-	if interval*2 > windowSize {
-		errStrings = append(errStrings, "window must be able to hold at least two intervals")
+	if latencyThreshold > 0 && (o.LatencySlowPercent <= 0 || o.LatencySlowPercent > 100) {
+		errStrings = append(errStrings, "latency-slow-percent must be > 0 and <= 100")
+	}
+
+	if o.RateLimitRequests > 0 && (rateLimitSeconds < 1 || rateLimitSeconds > windowSize) {
+		errStrings = append(errStrings, fmt.Sprintf("rate-limit-seconds of %s must be >= 1s and <= window-retention", bothForms(rateLimitSeconds)))
+	}
+
+	groups, groupsErr := parseClientGroups(o.ClientGroups)
+	if groupsErr != nil {
+		errStrings = append(errStrings, groupsErr.Error())
+	}
+
+	excluded := splitList(o.ExcludeCIDRs)
+	if _, err := clients.InitClassifier(groups, excluded); err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	if _, err := filter.Compile(o.Filter); err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	if _, err := webstats.ParseLateDataPolicy(o.LateData); err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	if _, err := parsing.NewParser(o.InputFormat); err != nil {
+		errStrings = append(errStrings, err.Error())
+	}
+
+	speed, speedErr := parseReplaySpeed(o.ReplaySpeed)
+	if speedErr != nil {
+		errStrings = append(errStrings, speedErr.Error())
+	}
+
+	if o.Follow && speed > 0 {
+		errStrings = append(errStrings, "replay-speed can't be used with follow, live input is already in real time")
+	}
+
+	if o.StateFilepath != "" && !o.Follow {
+		errStrings = append(errStrings, "state-filepath can only be used with follow, other input is read from the start")
+	}
+
+	if o.StateFilepath != "" && stateSaveInterval < 1 {
+		errStrings = append(errStrings, "state-save-interval cannot be < 1s")
+	}
+
+	if o.ReportFormat != "" && o.ReportFormat != "text" && o.ReportFormat != "html" {
+		errStrings = append(errStrings, fmt.Sprintf("%q is an invalid report-format, expected text or html", o.ReportFormat))
+	}
+
+	if reorderTolerance >= windowSize {
+		errStrings = append(errStrings, fmt.Sprintf("reorder-tolerance of %s must be < window-retention", bothForms(reorderTolerance)))
+	}
+
+	// longer intervals are read from a rollup, see `webstats.RollupForInterval`
+	if _, ok := webstats.RollupForInterval(rollups, interval); interval*2 > windowSize && !ok {
+		errStrings = append(errStrings, fmt.Sprintf("window-retention of %s must be able to hold at least two intervals of %s, or one of the rollups has to with a resolution the interval is a multiple of", bothForms(windowSize), bothForms(interval)))
 	}
 
-	if _, err := os.Stat(inputFilepath); os.IsNotExist(err) {
-		errStrings = append(errStrings, fmt.Sprintf("input filepath %s does not exist", inputFilepath))
+	if _, err := os.Stat(o.InputFilepath); os.IsNotExist(err) {
+		errStrings = append(errStrings, fmt.Sprintf("input filepath %s does not exist", o.InputFilepath))
 	}
 
 	var err error
@@ -48,9 +225,29 @@ func InitConfig(interval, windowSize, alarmThreshold uint, inputFilepath string)
 	}
 
 	return Config{
-		Interval:       uint64(interval),
-		WindowSize:     windowSize,
-		AlarmThreshold: alarmThreshold,
-		InputFilepath:  inputFilepath,
+		Interval:           interval,
+		WindowSize:         uint(windowSize),
+		Rollups:            rollups,
+		AlarmThreshold:     o.AlarmThreshold,
+		LatencyThreshold:   uint(latencyThreshold),
+		LatencySlowPercent: o.LatencySlowPercent,
+		TopClients:         o.TopClients,
+		MaxSections:        o.MaxSections,
+		RateLimitRequests:  o.RateLimitRequests,
+		RateLimitSeconds:   uint(rateLimitSeconds),
+		ClientGroups:       groups,
+		ExcludeCIDRs:       excluded,
+		Filter:             o.Filter,
+		LateData:           o.LateData,
+		ReorderTolerance:   reorderTolerance,
+		InputFilepath:      o.InputFilepath,
+		InputFormat:        o.InputFormat,
+		Follow:             o.Follow,
+		ReplaySpeed:        speed,
+		StateFilepath:      o.StateFilepath,
+		StateSaveInterval:  uint(stateSaveInterval),
+		ReportFormat:       o.ReportFormat,
+		ExportCSV:          o.ExportCSV,
+		ExportParquet:      o.ExportParquet,
 	}, err
 }
@@ -0,0 +1,73 @@
+package manage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a flag value for time settings. It accepts go durations like 10s, 5m or 2h, and plain
+// integers in Unit so that settings written before durations were supported still work.
+type Duration struct {
+	time.Duration
+	Unit time.Duration
+}
+
+// Seconds returns a Duration of n seconds where plain integers are seconds
+func Seconds(n uint) Duration {
+	return Duration{Duration: time.Duration(n) * time.Second, Unit: time.Second}
+}
+
+// Milliseconds returns a Duration of n milliseconds where plain integers are milliseconds
+func Milliseconds(n uint) Duration {
+	return Duration{Duration: time.Duration(n) * time.Millisecond, Unit: time.Millisecond}
+}
+
+// Set parses a go duration or a plain integer in Unit
+func (d *Duration) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+		d.Duration = time.Duration(n) * d.unit()
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("expected a duration like 10s, 5m or 2h, or a plain integer in %s", unitName(d.unit()))
+	}
+	if duration < 0 {
+		return fmt.Errorf("%s can't be negative", value)
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+func (d *Duration) unit() time.Duration {
+	if d.Unit == 0 {
+		return time.Second
+	}
+	return d.Unit
+}
+
+// inUnits converts d to a whole number of unit for Config. It adds an error for the setting called name
+// if there'd be a remainder, since the stats are only kept at that precision.
+func (d Duration) inUnits(name string, unit time.Duration, errStrings *[]string) uint64 {
+	if d.Duration%unit != 0 {
+		*errStrings = append(*errStrings, fmt.Sprintf("%s of %v must be a whole number of %s", name, d.Duration, unitName(unit)))
+	}
+	return uint64(d.Duration / unit)
+}
+
+func unitName(unit time.Duration) string {
+	if unit == time.Millisecond {
+		return "milliseconds"
+	}
+	return "seconds"
+}
+
+// bothForms shows seconds as a duration and as the plain integer that older settings used, e.g. "2m0s (120 seconds)"
+func bothForms(seconds uint64) string {
+	return fmt.Sprintf("%v (%d seconds)", time.Duration(seconds)*time.Second, seconds)
+}
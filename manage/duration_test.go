@@ -0,0 +1,72 @@
+package manage_test
+
+import (
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/manage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Duration", func() {
+	It("accepts go durations and plain integers in its unit", func() {
+		d := manage.Seconds(0)
+		Expect(d.Set("5m")).To(BeNil())
+		Expect(d.Duration).To(Equal(5 * time.Minute))
+		Expect(d.Set("90")).To(BeNil())
+		Expect(d.Duration).To(Equal(90 * time.Second))
+
+		ms := manage.Milliseconds(0)
+		Expect(ms.Set("500")).To(BeNil())
+		Expect(ms.Duration).To(Equal(500 * time.Millisecond))
+
+		Expect(d.Set("ten seconds")).ToNot(BeNil())
+		Expect(d.Set("-5s")).ToNot(BeNil())
+	})
+
+	It("is converted to whole seconds with errors that show both forms", func() {
+		options := manage.Options{
+			Interval:       manage.Duration{Duration: 1500 * time.Millisecond},
+			WindowSize:     manage.Seconds(60),
+			AlarmThreshold: 10,
+			InputFilepath:  "../input_files/sample_csv.txt",
+		}
+		_, err := manage.InitConfig(options)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("interval of 1.5s must be a whole number of seconds"))
+		Expect(err.Error()).To(ContainSubstring("window-retention of 1m0s (60 seconds) cannot be < 2m0s (120 seconds)"))
+
+		options.Interval = manage.Duration{Duration: time.Minute}
+		options.WindowSize = manage.Duration{Duration: 10 * time.Minute}
+		config, err := manage.InitConfig(options)
+		Expect(err).To(BeNil())
+		Expect(config.Interval).To(Equal(uint64(60)))
+		Expect(config.WindowSize).To(Equal(uint(600)))
+	})
+
+	It("reads intervals the window can't hold from rollups", func() {
+		options := manage.Options{
+			Interval:       manage.Duration{Duration: 15 * time.Minute},
+			WindowSize:     manage.Duration{Duration: 11 * time.Minute},
+			AlarmThreshold: 10,
+			InputFilepath:  "../input_files/sample_csv.txt",
+		}
+		_, err := manage.InitConfig(options)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("must be able to hold at least two intervals of 15m0s (900 seconds)"))
+
+		options.WindowSize = manage.Duration{Duration: 10 * time.Minute}
+		options.Rollups = "1m:24h,1h:720h"
+		config, err := manage.InitConfig(options)
+		Expect(err).To(BeNil())
+		Expect(config.Rollups).To(HaveLen(2))
+
+		options.Interval = manage.Duration{Duration: 15*time.Minute + time.Second}
+		_, err = manage.InitConfig(options)
+		Expect(err).ToNot(BeNil())
+
+		options.Rollups = "1m"
+		_, err = manage.InitConfig(options)
+		Expect(err.Error()).To(ContainSubstring(`rollup "1m" must be in the form resolution:retention`))
+	})
+})
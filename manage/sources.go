@@ -0,0 +1,122 @@
+package manage
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the name of every environment variable setting, e.g. APACHE_LOG_PARSER_ALARM_THRESHOLD
+// for the alarm-threshold flag
+const EnvPrefix = "APACHE_LOG_PARSER_"
+
+// ConfigFlag is the flag with the path to the config file
+const ConfigFlag = "config"
+
+// EnvName returns the environment variable for a flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// MergeSources fills in every flag that wasn't given on the command line from the environment, or else from
+// the yaml config file named by the config flag. Settings are named after the flags everywhere, so the
+// precedence is command line flags, then environment variables, then the config file, then the defaults.
+// It returns a message for every setting that couldn't be read or applied, so they can be reported along
+// with the rest of the validation, see `Options.SourceErrors`.
+func MergeSources(flags *flag.FlagSet, lookupEnv func(string) (string, bool)) []string {
+	setOnCommandLine := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	errStrings := []string{}
+	set := func(name, value, source string) {
+		previous := flags.Lookup(name).Value.String()
+		if err := flags.Set(name, value); err != nil {
+			errStrings = append(errStrings, fmt.Sprintf("%s: invalid value %q: %v", source, value, err))
+			flags.Set(name, previous) // numeric flags are zeroed by an invalid value, which isn't worth reporting as well
+		}
+	}
+
+	if !setOnCommandLine[ConfigFlag] {
+		if value, ok := lookupEnv(EnvName(ConfigFlag)); ok {
+			set(ConfigFlag, value, EnvName(ConfigFlag))
+		}
+	}
+
+	fileSettings := map[string]string{}
+	if configFlag := flags.Lookup(ConfigFlag); configFlag != nil && configFlag.Value.String() != "" {
+		settings, err := readConfigFile(configFlag.Value.String())
+		if err != nil {
+			errStrings = append(errStrings, err.Error())
+		} else {
+			fileSettings = settings
+		}
+	}
+
+	for name := range fileSettings {
+		if name == ConfigFlag || flags.Lookup(name) == nil {
+			errStrings = append(errStrings, fmt.Sprintf("config file: unknown setting %q", name))
+		}
+	}
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if setOnCommandLine[f.Name] || f.Name == ConfigFlag {
+			return
+		}
+
+		if value, ok := lookupEnv(EnvName(f.Name)); ok {
+			set(f.Name, value, EnvName(f.Name))
+		} else if value, ok := fileSettings[f.Name]; ok {
+			set(f.Name, value, "config file "+f.Name)
+		}
+	})
+
+	sort.Strings(errStrings)
+	return errStrings
+}
+
+// readConfigFile reads the settings from a yaml file as flag values. Lists are joined with commas and
+// client groups can be given as a map of labels to CIDRs, the same as the flag formats.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	settings := map[string]string{}
+	for name, value := range raw {
+		settings[name] = settingValue(value)
+	}
+	return settings, nil
+}
+
+func settingValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = settingValue(item)
+		}
+		return strings.Join(values, ",")
+	case map[interface{}]interface{}:
+		groups := make([]string, 0, len(v))
+		for label, cidrs := range v {
+			groups = append(groups, fmt.Sprintf("%v=%s", label, settingValue(cidrs)))
+		}
+		sort.Strings(groups)
+		return strings.Join(groups, ";")
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,170 @@
+package manage_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/manage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestManage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Manage Suite")
+}
+
+var _ = Describe("MergeSources", func() {
+	var dir string
+	var flags *flag.FlagSet
+	var options manage.Options
+	var env map[string]string
+
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	writeConfig := func(yaml string) string {
+		path := filepath.Join(dir, "config.yaml")
+		Expect(ioutil.WriteFile(path, []byte(yaml), 0644)).To(BeNil())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "manage")
+		Expect(err).To(BeNil())
+
+		options = manage.Options{}
+		env = map[string]string{}
+		flags = flag.NewFlagSet("test", flag.ContinueOnError)
+		options.Interval = manage.Seconds(10)
+		flags.Var(&options.Interval, "interval", "")
+		flags.UintVar(&options.AlarmThreshold, "alarm-threshold", 10, "")
+		options.WindowSize = manage.Seconds(240)
+		flags.Var(&options.WindowSize, "window-retention", "")
+		flags.StringVar(&options.ClientGroups, "client-groups", "", "")
+		flags.StringVar(&options.ExcludeCIDRs, "exclude-cidrs", "", "")
+		flags.String(manage.ConfigFlag, "", "")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("prefers flags, then the environment, then the config file", func() {
+		path := writeConfig("interval: 20\nalarm-threshold: 20\nwindow-retention: 300\n")
+		env[manage.EnvName("alarm-threshold")] = "30"
+		env[manage.EnvName("interval")] = "30"
+		Expect(flags.Parse([]string{"-config", path, "-interval", "40"})).To(BeNil())
+
+		Expect(manage.MergeSources(flags, lookupEnv)).To(BeEmpty())
+		Expect(options.Interval.Duration).To(Equal(40 * time.Second))
+		Expect(options.AlarmThreshold).To(Equal(uint(30)))
+		Expect(options.WindowSize.Duration).To(Equal(300 * time.Second))
+	})
+
+	It("reads the config file named in the environment", func() {
+		env["APACHE_LOG_PARSER_CONFIG"] = writeConfig("interval: 20\n")
+		Expect(flags.Parse([]string{})).To(BeNil())
+
+		Expect(manage.MergeSources(flags, lookupEnv)).To(BeEmpty())
+		Expect(options.Interval.Duration).To(Equal(20 * time.Second))
+	})
+
+	It("converts yaml lists and maps to the flag formats", func() {
+		path := writeConfig("exclude-cidrs: [10.0.5.0/24, 10.0.6.0/24]\nclient-groups:\n  office: [192.168.1.0/24]\n  internal: 10.0.0.0/8\n")
+		Expect(flags.Parse([]string{"-config", path})).To(BeNil())
+
+		Expect(manage.MergeSources(flags, lookupEnv)).To(BeEmpty())
+		Expect(options.ExcludeCIDRs).To(Equal("10.0.5.0/24,10.0.6.0/24"))
+		Expect(options.ClientGroups).To(Equal("internal=10.0.0.0/8;office=192.168.1.0/24"))
+	})
+
+	It("reports every invalid setting", func() {
+		path := writeConfig("intervall: 20\nwindow-retention: lots\n")
+		env[manage.EnvName("interval")] = "-1"
+		Expect(flags.Parse([]string{"-config", path})).To(BeNil())
+
+		errStrings := manage.MergeSources(flags, lookupEnv)
+		Expect(errStrings).To(HaveLen(3))
+		Expect(errStrings).To(ContainElement(ContainSubstring(`unknown setting "intervall"`)))
+		Expect(errStrings).To(ContainElement(ContainSubstring("window-retention")))
+		Expect(errStrings).To(ContainElement(ContainSubstring("APACHE_LOG_PARSER_INTERVAL")))
+	})
+
+	It("reports them with the validation errors", func() {
+		env[manage.EnvName("config")] = filepath.Join(dir, "missing.yaml")
+		env[manage.EnvName("alarm-threshold")] = "lots"
+		Expect(flags.Parse([]string{"-interval", "0"})).To(BeNil())
+
+		options.SourceErrors = manage.MergeSources(flags, lookupEnv)
+		options.InputFilepath = "../input_files/sample_csv.txt"
+		_, err := manage.InitConfig(options)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("error reading config file"))
+		Expect(err.Error()).To(ContainSubstring("APACHE_LOG_PARSER_ALARM_THRESHOLD"))
+		Expect(err.Error()).To(ContainSubstring("interval cannot be < 1s"))
+		Expect(err.Error()).ToNot(ContainSubstring("alarmThreshold")) // the invalid value isn't applied
+	})
+})
+
+var _ = Describe("InitConfig", func() {
+	It("validates the merged options together", func() {
+		_, err := manage.InitConfig(manage.Options{
+			Interval:       manage.Seconds(0),
+			WindowSize:     manage.Seconds(240),
+			AlarmThreshold: 10,
+			LateData:       "keep",
+			InputFilepath:  "../input_files/sample_csv.txt",
+			ReportFormat:   "pdf",
+			InputFormat:    "json",
+		})
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("interval cannot be < 1"))
+		Expect(err.Error()).To(ContainSubstring("invalid late data policy"))
+		Expect(err.Error()).To(ContainSubstring(`"pdf" is an invalid report-format`))
+		Expect(err.Error()).To(ContainSubstring(`"json" is an invalid input format`))
+	})
+
+	It("parses client groups", func() {
+		options := manage.Options{
+			Interval:       manage.Seconds(10),
+			WindowSize:     manage.Seconds(240),
+			AlarmThreshold: 10,
+			InputFilepath:  "../input_files/sample_csv.txt",
+		}
+
+		for _, v := range []struct {
+			clientGroups string
+			expected     map[string][]string
+			err          string
+		}{
+			{"", map[string][]string{}, ""},
+			{" internal = 10.0.0.0/8 ; office=192.168.1.0/24, 192.168.2.0/24;", map[string][]string{
+				"internal": {"10.0.0.0/8"},
+				"office":   {"192.168.1.0/24", "192.168.2.0/24"},
+			}, ""},
+			{"office=192.168.1.0/24;office=192.168.2.0/24", map[string][]string{"office": {"192.168.1.0/24", "192.168.2.0/24"}}, ""},
+			{"10.0.0.0/8", nil, `client group "10.0.0.0/8" must be in the form label=cidr,cidr`},
+			{"=10.0.0.0/8", nil, `client group "=10.0.0.0/8" must be in the form label=cidr,cidr`},
+			{"internal=", nil, `client group "internal" has no CIDRs`},
+			{"internal=10.0.0.0/33", nil, `invalid CIDR "10.0.0.0/33" for client group internal`},
+			{"internal=10.0.0.1", nil, `invalid CIDR "10.0.0.1" for client group internal`},
+		} {
+			options.ClientGroups = v.clientGroups
+			config, err := manage.InitConfig(options)
+			if v.err != "" {
+				Expect(err).To(MatchError(ContainSubstring(v.err)), v.clientGroups)
+				continue
+			}
+			Expect(err).To(BeNil(), v.clientGroups)
+			Expect(config.ClientGroups).To(Equal(v.expected), v.clientGroups)
+		}
+	})
+})
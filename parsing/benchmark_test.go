@@ -0,0 +1,109 @@
+package parsing_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/parsing"
+)
+
+const benchmarkLines = 10000
+
+var sections = []string{"/api", "/report", "/static", "/login", "/search"}
+
+func csvInput() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`"remotehost","rfc931","authuser","date","request","status","bytes","latency"` + "\n")
+	for i := 0; i < benchmarkLines; i++ {
+		fmt.Fprintf(&buf, `"10.0.%d.%d","-","apache",%d,"GET %s/item/%d HTTP/1.0",200,%d,%d`+"\n",
+			i/256%256, i%256, 1549573860+i/100, sections[i%len(sections)], i, 1000+i%5000, 200+i%3000)
+	}
+	return buf.Bytes()
+}
+
+func clfInput() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < benchmarkLines; i++ {
+		date := time.Unix(int64(1549573860+i/100), 0).UTC().Format("02/Jan/2006:15:04:05 -0700")
+		fmt.Fprintf(&buf, `10.0.%d.%d - apache [%s] "GET %s/item/%d HTTP/1.0" 200 %d`+"\n",
+			i/256%256, i%256, date, sections[i%len(sections)], i, 1000+i%5000)
+	}
+	return buf.Bytes()
+}
+
+// benchmarkStream reports lines/s for a parser that sends lines on a channel, the way the pipeline reads them
+func benchmarkStream(b *testing.B, input []byte, parse func(io.Reader, chan parsing.WebServerLogData) error) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+
+	lines := 0
+	for i := 0; i < b.N; i++ {
+		c := make(chan parsing.WebServerLogData, 1000)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- parse(bytes.NewReader(input), c)
+		}()
+		for range c {
+			lines++
+		}
+		if err := <-errCh; err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(lines)/time.Since(start).Seconds(), "lines/s")
+}
+
+func BenchmarkParseReflection(b *testing.B) {
+	benchmarkStream(b, csvInput(), parsing.ParseWebServerLogDataWithChannel)
+}
+
+func BenchmarkParseCSV(b *testing.B) {
+	benchmarkStream(b, csvInput(), func(r io.Reader, c chan parsing.WebServerLogData) error {
+		return parsing.ParseWithChannel(r, parsing.FormatCSV, c)
+	})
+}
+
+func BenchmarkParseCLF(b *testing.B) {
+	benchmarkStream(b, clfInput(), func(r io.Reader, c chan parsing.WebServerLogData) error {
+		return parsing.ParseWithChannel(r, parsing.FormatCLF, c)
+	})
+}
+
+// benchmarkParser parses lines without a channel, so it's the cost of the parser alone
+func benchmarkParser(b *testing.B, format string, input []byte) {
+	lines := bytes.SplitAfter(input, []byte("\n"))
+	parser, err := parsing.NewParser(format)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if parser.HasHeader() {
+		parser.Parse(lines[0], &parsing.WebServerLogData{})
+		lines = lines[1 : len(lines)-1]
+	} else {
+		lines = lines[:len(lines)-1]
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	var data parsing.WebServerLogData
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.Parse(lines[i%len(lines)], &data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "lines/s")
+}
+
+func BenchmarkParserCSVLine(b *testing.B) {
+	benchmarkParser(b, parsing.FormatCSV, csvInput())
+}
+
+func BenchmarkParserCLFLine(b *testing.B) {
+	benchmarkParser(b, parsing.FormatCLF, clfInput())
+}
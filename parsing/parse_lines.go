@@ -0,0 +1,70 @@
+package parsing
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hardboiled/apache-log-parser/checkpoint"
+)
+
+// LineReader reads one complete line at a time along with the position where it ends, e.g. `tailing.Follower`
+type LineReader interface {
+	ReadLine() ([]byte, checkpoint.Position, error)
+}
+
+// Columns are the csv columns that are read into WebServerLogData, any others are ignored
+var Columns = []string{"remotehost", "rfc931", "authuser", "date", "request", "status", "bytes", "latency"}
+
+// IsColumn returns true if column is read into WebServerLogData
+func IsColumn(column string) bool {
+	_, ok := columnsByName[column]
+	return ok
+}
+
+func parseUint(v string, field *uint64) error {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		*field = 0
+		return nil
+	}
+
+	n, err := strconv.ParseUint(v, 0, 64)
+	*field = n
+	return err
+}
+
+// ParseLinesWithChannel works like `ParseWebServerLogDataWithChannel`, except each line is parsed on its own
+// so that its position can be recorded in `WebServerLogData.Position`
+func ParseLinesWithChannel(lines LineReader, c chan WebServerLogData) error {
+	return ParseLinesWithFormat(lines, FormatCSV, c)
+}
+
+// ParseLinesWithFormat is `ParseLinesWithChannel` for any of the input formats
+func ParseLinesWithFormat(lines LineReader, format string, c chan WebServerLogData) error {
+	defer close(c)
+
+	parser, err := NewParser(format)
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, position, err := lines.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		data := WebServerLogData{Position: position}
+		ok, err := parser.Parse(line, &data)
+		if err != nil {
+			return err
+		}
+		if ok {
+			c <- data
+		}
+	}
+}
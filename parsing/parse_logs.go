@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/gocarina/gocsv"
+	"github.com/hardboiled/apache-log-parser/checkpoint"
 )
 
 // WebServerLogData represents one line from a csv (only export used lines)
@@ -16,16 +17,45 @@ type WebServerLogData struct {
 	Request    string `csv:"request"`
 	Status     uint64 `csv:"status"`
 	Bytes      uint64 `csv:"bytes"`
+	Latency    uint64 `csv:"latency"` // optional, microseconds taken to serve the request (apache %D)
+
+	Position checkpoint.Position `csv:"-"` // where the line ends in the file, only set when following a file
 }
 
-// ParseWebServerLogDataWithChannel will send back each parsed line through the provided channel
-func ParseWebServerLogDataWithChannel(stream io.ReadCloser, c chan WebServerLogData) {
-	gocsv.UnmarshalToChan(stream, c)
+// ParseWebServerLogDataWithChannel will send back each parsed line through the provided channel.
+// The channel is closed when finished, and the error that stopped parsing is returned, if any.
+// Columns are set with reflection, `ParseWithChannel` is several times faster.
+func ParseWebServerLogDataWithChannel(stream io.Reader, c chan WebServerLogData) error {
+	err := gocsv.UnmarshalToChan(stream, c)
+	if err == io.EOF {
+		return nil // an empty stream without a header
+	}
+	return err
 }
 
+// NoSection is the section of requests without a path, like the "-" apache logs when a client times out
+// before sending its request (status 408)
+const NoSection = "-"
+
 // RequestSection takes the request and finds the section associated with it
 func (ld *WebServerLogData) RequestSection() string {
 	startIdx := strings.IndexAny(ld.Request, "/")
-	endIdx := strings.IndexAny(ld.Request[startIdx+1:], " /") + startIdx + 1
-	return ld.Request[startIdx:endIdx]
+	if startIdx < 0 {
+		return NoSection
+	}
+
+	endIdx := strings.IndexAny(ld.Request[startIdx+1:], " /")
+	if endIdx < 0 {
+		return ld.Request[startIdx:]
+	}
+	return ld.Request[startIdx : endIdx+startIdx+1]
+}
+
+// RequestMethod returns the http method of the request, e.g. GET
+func (ld *WebServerLogData) RequestMethod() string {
+	endIdx := strings.IndexAny(ld.Request, " ")
+	if endIdx < 0 {
+		return ld.Request
+	}
+	return ld.Request[:endIdx]
 }
@@ -2,8 +2,11 @@ package parsing_test
 
 import (
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 
+	"github.com/hardboiled/apache-log-parser/checkpoint"
 	"github.com/hardboiled/apache-log-parser/parsing"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -28,5 +31,154 @@ var _ = Describe("WebServerLogData", func() {
 		Expect(ld.RequestSection()).To(Equal("/api"))
 		ld.Request = fmt.Sprintf(fmtStr, "/some-other-section/hello/2")
 		Expect(ld.RequestSection()).To(Equal("/some-other-section"))
+		ld.Request = "GET /api"
+		Expect(ld.RequestSection()).To(Equal("/api"))
+		ld.Request = "-"
+		Expect(ld.RequestSection()).To(Equal(parsing.NoSection))
+		ld.Request = ""
+		Expect(ld.RequestSection()).To(Equal(parsing.NoSection))
+	})
+
+	It("returns correct RequestMethod", func() {
+		ld := parsing.WebServerLogData{Request: "HEAD /api HTTP/1.0"}
+		Expect(ld.RequestMethod()).To(Equal("HEAD"))
+		ld.Request = "GET"
+		Expect(ld.RequestMethod()).To(Equal("GET"))
+	})
+
+	It("parses lines one at a time with their positions", func() {
+		lines := &fakeLineReader{lines: []string{
+			`"date","remotehost","request","status","latency","unknown"` + "\n",
+			`1549573860,"10.0.0.1","GET /api/user HTTP/1.0",200,1500,"x"` + "\n",
+			`1549573861,"10.0.0.2","GET /report HTTP/1.0",,,"y"` + "\n",
+		}}
+		c := make(chan parsing.WebServerLogData, 10)
+		Expect(parsing.ParseLinesWithChannel(lines, c)).To(BeNil())
+
+		results := []parsing.WebServerLogData{}
+		for data := range c {
+			results = append(results, data)
+		}
+		Expect(len(results)).To(Equal(2))
+		Expect(results[0].Date).To(Equal(uint64(1549573860)))
+		Expect(results[0].RemoteHost).To(Equal("10.0.0.1"))
+		Expect(results[0].Latency).To(Equal(uint64(1500)))
+		Expect(results[0].Position.Offset).To(Equal(int64(2)))
+		Expect(results[1].Status).To(Equal(uint64(0)))
+		Expect(results[1].RequestSection()).To(Equal("/report"))
+	})
+
+	It("knows which columns are read", func() {
+		Expect(parsing.IsColumn("latency")).To(Equal(true))
+		Expect(parsing.IsColumn("referer")).To(Equal(false))
+		for _, column := range parsing.Columns {
+			Expect(parsing.IsColumn(column)).To(Equal(true))
+		}
+	})
+
+	It("fails on invalid numbers", func() {
+		lines := &fakeLineReader{lines: []string{`"date"` + "\n", `"yesterday"` + "\n"}}
+		c := make(chan parsing.WebServerLogData, 10)
+		Expect(parsing.ParseLinesWithChannel(lines, c)).ToNot(BeNil())
+	})
+})
+
+var _ = Describe("Parser", func() {
+	collect := func(input string, format string) ([]parsing.WebServerLogData, error) {
+		c := make(chan parsing.WebServerLogData, 10)
+		err := parsing.ParseWithChannel(strings.NewReader(input), format, c)
+		results := []parsing.WebServerLogData{}
+		for data := range c {
+			results = append(results, data)
+		}
+		return results, err
+	}
+
+	It("parses csv the same way as the reflection based parser", func() {
+		input := `"date","remotehost","request","status","bytes","latency","unknown"` + "\r\n" +
+			`1549573860,"10.0.0.1","GET /api/user?q=""a,b"" HTTP/1.0",200,0x10,1500,"x"` + "\r\n" +
+			"\n" +
+			`1549573861,10.0.0.2,"GET /report HTTP/1.0", 404 ,,,` + "\n" +
+			`1549573862,"10.0.0.3","GET /api HTTP/1.0",010,1,2,3`
+
+		results, err := collect(input, parsing.FormatCSV)
+		Expect(err).To(BeNil())
+
+		c := make(chan parsing.WebServerLogData, 10)
+		Expect(parsing.ParseWebServerLogDataWithChannel(strings.NewReader(input), c)).To(BeNil())
+		expected := []parsing.WebServerLogData{}
+		for data := range c {
+			expected = append(expected, data)
+		}
+		Expect(results).To(Equal(expected))
+		Expect(results[0].Request).To(Equal(`GET /api/user?q="a,b" HTTP/1.0`))
+		Expect(results[0].Bytes).To(Equal(uint64(16)))
+		Expect(results[1].Status).To(Equal(uint64(404)))
+	})
+
+	It("parses the common and combined log formats", func() {
+		results, err := collect(
+			`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`+"\n"+
+				`10.0.0.2 - - [07/Feb/2019:21:11:00 +0000] "GET /api/user?q=\"x\" HTTP/1.1" 304 - "http://example.com/" "Mozilla/5.0"`+"\n"+
+				`10.0.0.3 - - [07/Feb/2019:21:11:01 +0000] "-" 408 -`+"\n",
+			parsing.FormatCLF,
+		)
+		Expect(err).To(BeNil())
+		Expect(results).To(Equal([]parsing.WebServerLogData{
+			{RemoteHost: "127.0.0.1", Rfc931: "-", AuthUser: "frank", Date: 971211336, Request: "GET /apache_pb.gif HTTP/1.0", Status: 200, Bytes: 2326},
+			{RemoteHost: "10.0.0.2", Rfc931: "-", AuthUser: "-", Date: 1549573860, Request: `GET /api/user?q=\"x\" HTTP/1.1`, Status: 304},
+			{RemoteHost: "10.0.0.3", Rfc931: "-", AuthUser: "-", Date: 1549573861, Request: "-", Status: 408},
+		}))
+		Expect(results[2].RequestSection()).To(Equal(parsing.NoSection))
+	})
+
+	It("fails on rows that are shorter than the header, like a line that's still being written", func() {
+		input := `"remotehost","rfc931","authuser","date"` + "\n" + `"10.0.0.9","-","apache"` + "\n"
+		results, err := collect(input, parsing.FormatCSV)
+		Expect(err).To(MatchError(ContainSubstring("wrong number of fields")))
+		Expect(results).To(BeEmpty())
+		Expect(parsing.ParseWebServerLogDataWithChannel(strings.NewReader(input), make(chan parsing.WebServerLogData, 10))).ToNot(BeNil())
+	})
+
+	It("fails on rows that are longer than the header", func() {
+		input := `"remotehost","date"` + "\n" + `"10.0.0.9",1549573860,"extra"` + "\n"
+		results, err := collect(input, parsing.FormatCSV)
+		Expect(err).To(MatchError(ContainSubstring("wrong number of fields")))
+		Expect(results).To(BeEmpty())
+		Expect(parsing.ParseWebServerLogDataWithChannel(strings.NewReader(input), make(chan parsing.WebServerLogData, 10))).ToNot(BeNil())
+	})
+
+	It("reads lines longer than its buffer", func() {
+		request := "GET /api/" + strings.Repeat("x", 100*1024) + " HTTP/1.0"
+		results, err := collect(`"request"`+"\n"+`"`+request+`"`+"\n", parsing.FormatCSV)
+		Expect(err).To(BeNil())
+		Expect(len(results)).To(Equal(1))
+		Expect(results[0].Request).To(Equal(request))
+	})
+
+	It("fails on malformed lines", func() {
+		_, err := collect(`"request"`+"\n"+`"GET /api`+"\n", parsing.FormatCSV)
+		Expect(err).To(MatchError(ContainSubstring("unterminated quoted field")))
+		_, err = collect(`"request","status"`+"\n"+`"GET /api"x,200`+"\n", parsing.FormatCSV)
+		Expect(err).To(MatchError(ContainSubstring(`extraneous "`)))
+		_, err = collect(`127.0.0.1 - - [10/Oct/2000 13:55:36] "GET / HTTP/1.0" 200 1`+"\n", parsing.FormatCLF)
+		Expect(err).To(MatchError(ContainSubstring("invalid date")))
+		_, err = collect(`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" ok 1`+"\n", parsing.FormatCLF)
+		Expect(err).To(MatchError(ContainSubstring("invalid status")))
+		_, err = collect("", "json")
+		Expect(err).To(MatchError(ContainSubstring(`"json" is an invalid input format`)))
 	})
 })
+
+type fakeLineReader struct {
+	lines []string
+	read  int
+}
+
+func (lr *fakeLineReader) ReadLine() ([]byte, checkpoint.Position, error) {
+	if lr.read == len(lr.lines) {
+		return nil, checkpoint.Position{}, io.EOF
+	}
+	lr.read++
+	return []byte(lr.lines[lr.read-1]), checkpoint.Position{Offset: int64(lr.read)}, nil
+}
@@ -0,0 +1,378 @@
+package parsing
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Input formats that `NewParser` reads
+const (
+	FormatCSV = "csv" // csv with a header naming the columns, see `Columns`
+	FormatCLF = "clf" // apache's common log format, the extra fields of the combined format are ignored
+)
+
+// streamBufferSize is how much of a stream is read at once, longer lines are still read whole
+const streamBufferSize = 64 * 1024
+
+type column int
+
+const (
+	ignored column = iota
+	remoteHost
+	rfc931
+	authUser
+	date
+	request
+	status
+	bytesSent
+	latency
+)
+
+// columnsByName maps the csv header to the WebServerLogData fields, the same way the csv tags do
+var columnsByName = map[string]column{
+	"remotehost": remoteHost,
+	"rfc931":     rfc931,
+	"authuser":   authUser,
+	"date":       date,
+	"request":    request,
+	"status":     status,
+	"bytes":      bytesSent,
+	"latency":    latency,
+}
+
+// field is where a csv field is in its line
+type field struct {
+	start, end int
+	escaped    bool // quoted with "" inside, which has to be unescaped
+}
+
+// Parser parses log lines into WebServerLogData without reflection. Each line is converted to a string
+// once and the string fields are slices of it, so a line only allocates its text, unless a csv field
+// has escaped quotes.
+type Parser struct {
+	format  string
+	header  []string
+	columns []column // what each csv column is read into, nil until the header is parsed
+	fields  []field  // reused for every line
+}
+
+// NewParser returns a parser for format, "" is csv
+func NewParser(format string) (*Parser, error) {
+	switch format {
+	case "":
+		format = FormatCSV
+	case FormatCSV, FormatCLF:
+	default:
+		return nil, fmt.Errorf("%q is an invalid input format, expected %s or %s", format, FormatCSV, FormatCLF)
+	}
+	return &Parser{format: format}, nil
+}
+
+// HasHeader returns true if the first line of the input is a header rather than a log line
+func (p *Parser) HasHeader() bool {
+	return p.format == FormatCSV
+}
+
+// Header returns the columns of the csv header once it's been parsed
+func (p *Parser) Header() []string {
+	return p.header
+}
+
+// Parse parses a line, with or without its line ending, into data and returns true if it was a log
+// line. Blank lines are skipped, and so is the first line of csv, which is its header.
+func (p *Parser) Parse(line []byte, data *WebServerLogData) (bool, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return false, nil
+	}
+
+	if p.format == FormatCLF {
+		return true, parseCLF(string(line), data)
+	}
+
+	if err := p.split(line); err != nil {
+		return false, err
+	}
+	text := string(line)
+
+	if p.columns == nil {
+		p.header = make([]string, len(p.fields))
+		p.columns = make([]column, len(p.fields))
+		for i, f := range p.fields {
+			p.header[i] = f.text(text)
+			p.columns[i] = columnsByName[p.header[i]]
+		}
+		return false, nil
+	}
+
+	// like encoding/csv, every row needs a field for each column, so a line that's cut short isn't counted
+	if len(p.fields) != len(p.columns) {
+		return false, fmt.Errorf("wrong number of fields in %q, expected %d like the header but got %d", text, len(p.columns), len(p.fields))
+	}
+
+	for i, f := range p.fields {
+		if err := p.set(data, p.columns[i], f, text); err != nil {
+			return false, fmt.Errorf("error parsing column %s of %q: %v", p.header[i], text, err)
+		}
+	}
+	return true, nil
+}
+
+func (p *Parser) set(data *WebServerLogData, c column, f field, text string) error {
+	switch c {
+	case remoteHost:
+		data.RemoteHost = f.text(text)
+	case rfc931:
+		data.Rfc931 = f.text(text)
+	case authUser:
+		data.AuthUser = f.text(text)
+	case date:
+		return parseUintField(text[f.start:f.end], &data.Date)
+	case request:
+		data.Request = f.text(text)
+	case status:
+		return parseUintField(text[f.start:f.end], &data.Status)
+	case bytesSent:
+		return parseUintField(text[f.start:f.end], &data.Bytes)
+	case latency:
+		return parseUintField(text[f.start:f.end], &data.Latency)
+	}
+	return nil
+}
+
+func (f field) text(text string) string {
+	if f.escaped {
+		return strings.Replace(text[f.start:f.end], `""`, `"`, -1)
+	}
+	return text[f.start:f.end]
+}
+
+// split finds the fields of a csv line, quoted fields don't include their quotes
+func (p *Parser) split(line []byte) error {
+	p.fields = p.fields[:0]
+	for i := 0; ; i++ {
+		f := field{start: i}
+		if i < len(line) && line[i] == '"' {
+			f.start = i + 1
+			for i = f.start; ; i += 2 {
+				end := bytes.IndexByte(line[i:], '"')
+				if end < 0 {
+					return fmt.Errorf("unterminated quoted field in %q", line)
+				}
+				i += end
+				if i+1 >= len(line) || line[i+1] != '"' {
+					break
+				}
+				f.escaped = true
+			}
+			f.end = i
+			i++
+			if i < len(line) && line[i] != ',' {
+				return fmt.Errorf("extraneous \" in field %d of %q", len(p.fields)+1, line)
+			}
+		} else {
+			end := bytes.IndexByte(line[i:], ',')
+			if end < 0 {
+				end = len(line) - i
+			}
+			i += end
+			f.end = i
+		}
+
+		p.fields = append(p.fields, f)
+		if i >= len(line) {
+			return nil
+		}
+	}
+}
+
+// parseUintField parses plain decimal numbers itself, and leaves anything else, like spaces or hex,
+// to `parseUint` so the result is the same as the other parsers
+func parseUintField(v string, field *uint64) error {
+	if len(v) > 1 && v[0] == '0' {
+		return parseUint(v, field) // octal or hex
+	}
+
+	n := uint64(0)
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < '0' || c > '9' || n > (math.MaxUint64-9)/10 {
+			return parseUint(v, field)
+		}
+		n = n*10 + uint64(c-'0')
+	}
+	*field = n
+	return nil
+}
+
+// parseCLF parses a line like
+// 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+// The common log format doesn't have the latency, so it's always 0.
+func parseCLF(line string, data *WebServerLogData) error {
+	rest := line
+	var ok bool
+	if data.RemoteHost, rest, ok = cut(rest, ' '); !ok {
+		return fmt.Errorf("%q isn't in the common log format, expected a remote host", line)
+	}
+	if data.Rfc931, rest, ok = cut(rest, ' '); !ok {
+		return fmt.Errorf("%q isn't in the common log format, expected an identity", line)
+	}
+	if data.AuthUser, rest, ok = cut(rest, ' '); !ok {
+		return fmt.Errorf("%q isn't in the common log format, expected a user", line)
+	}
+
+	end := strings.IndexByte(rest, ']')
+	if !strings.HasPrefix(rest, "[") || end < 0 {
+		return fmt.Errorf("%q isn't in the common log format, expected a [date]", line)
+	}
+	if data.Date, ok = parseCLFTime(rest[1:end]); !ok {
+		return fmt.Errorf("invalid date %q in %q, expected one like 10/Oct/2000:13:55:36 -0700", rest[1:end], line)
+	}
+	rest = strings.TrimPrefix(rest[end+1:], " ")
+
+	if !strings.HasPrefix(rest, `"`) {
+		return fmt.Errorf("%q isn't in the common log format, expected a quoted request", line)
+	}
+	end = closingQuote(rest[1:])
+	if end < 0 {
+		return fmt.Errorf("%q isn't in the common log format, the request isn't terminated", line)
+	}
+	data.Request = rest[1 : end+1]
+	rest = strings.TrimPrefix(rest[end+2:], " ")
+
+	var statusText, bytesText string
+	statusText, rest, _ = cut(rest, ' ')
+	bytesText, _, _ = cut(rest, ' ')
+	if err := parseUintField(statusText, &data.Status); err != nil || statusText == "" {
+		return fmt.Errorf("invalid status %q in %q", statusText, line)
+	}
+	if bytesText == "-" {
+		data.Bytes = 0 // nothing was sent
+	} else if err := parseUintField(bytesText, &data.Bytes); err != nil {
+		return fmt.Errorf("invalid bytes %q in %q", bytesText, line)
+	}
+	return nil
+}
+
+// cut returns the text before the first sep and the text after it, ok is false when there's no sep
+func cut(s string, sep byte) (before, after string, ok bool) {
+	if i := strings.IndexByte(s, sep); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+// closingQuote returns the index of the first quote that isn't escaped, apache logs quotes in the request as \"
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+var months = map[string]time.Month{
+	"Jan": time.January, "Feb": time.February, "Mar": time.March, "Apr": time.April,
+	"May": time.May, "Jun": time.June, "Jul": time.July, "Aug": time.August,
+	"Sep": time.September, "Oct": time.October, "Nov": time.November, "Dec": time.December,
+}
+
+// parseCLFTime parses a time like 10/Oct/2000:13:55:36 -0700 to a unix time. It's much faster than
+// `time.Parse`, which has to interpret the layout for every line.
+func parseCLFTime(s string) (uint64, bool) {
+	if len(s) != 26 || s[2] != '/' || s[6] != '/' || s[11] != ':' || s[14] != ':' || s[17] != ':' || s[20] != ' ' {
+		return 0, false
+	}
+
+	month, ok := months[s[3:6]]
+	day, ok1 := digits(s[0:2])
+	year, ok2 := digits(s[7:11])
+	hour, ok3 := digits(s[12:14])
+	minute, ok4 := digits(s[15:17])
+	second, ok5 := digits(s[18:20])
+	zoneHours, ok6 := digits(s[22:24])
+	zoneMinutes, ok7 := digits(s[24:26])
+	if !ok || !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 || !ok7 || (s[21] != '+' && s[21] != '-') ||
+		day < 1 || day > 31 || hour > 23 || minute > 59 || second > 60 {
+		return 0, false
+	}
+
+	offset := int64(zoneHours*3600 + zoneMinutes*60)
+	if s[21] == '-' {
+		offset = -offset
+	}
+
+	unix := time.Date(year, month, day, hour, minute, second, 0, time.UTC).Unix() - offset
+	if unix < 0 {
+		return 0, false
+	}
+	return uint64(unix), true
+}
+
+func digits(s string) (int, bool) {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		n = n*10 + int(s[i]-'0')
+	}
+	return n, true
+}
+
+// ParseWithChannel works like `ParseWebServerLogDataWithChannel` for any of the input formats, using a
+// `Parser` instead of reflection. The channel is closed when finished.
+func ParseWithChannel(stream io.Reader, format string, c chan WebServerLogData) error {
+	defer close(c)
+
+	parser, err := NewParser(format)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(stream, streamBufferSize)
+	var long []byte
+	for {
+		line, readErr := readLine(reader, &long)
+		data := WebServerLogData{}
+		ok, err := parser.Parse(line, &data)
+		if err != nil {
+			return err
+		}
+		if ok {
+			c <- data
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// readLine returns the next line, which is only valid until the next read. Lines longer than the buffer
+// are collected in long, so its memory is reused as well.
+func readLine(reader *bufio.Reader, long *[]byte) ([]byte, error) {
+	line, err := reader.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+
+	*long = append((*long)[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = reader.ReadSlice('\n')
+		*long = append(*long, line...)
+	}
+	return *long, err
+}
@@ -0,0 +1,13 @@
+package pipeline
+
+import "time"
+
+// SetClock replaces the clock that drives live mode, it returns a func that restores the real one
+func SetClock(clock func() time.Time, tick time.Duration) func() {
+	now = clock
+	tickInterval = tick
+	return func() {
+		now = time.Now
+		tickInterval = time.Second
+	}
+}
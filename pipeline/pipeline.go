@@ -0,0 +1,391 @@
+/*Package pipeline wires parsing, stats and output together so the analyzer
+ * can be embedded in other programs. It stops the go routines it starts when
+ * the context is cancelled and returns the first error. The one exception is
+ * the go routine reading the source, it can only be unblocked by closing the
+ * source, so if it isn't an io.Closer it keeps reading until EOF or an error.
+ */
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/checkpoint"
+	"github.com/hardboiled/apache-log-parser/manage"
+	"github.com/hardboiled/apache-log-parser/parsing"
+	"github.com/hardboiled/apache-log-parser/state"
+	"github.com/hardboiled/apache-log-parser/tailing"
+)
+
+// now and tickInterval drive the schedule in live mode, they're variables so tests can replace them
+var (
+	now          = time.Now
+	tickInterval = time.Second
+)
+
+const (
+	inputBufferSize    = 100
+	followPollInterval = 250 * time.Millisecond
+	endOfInput         = "end of input"
+	shutdownRequested  = "shutdown requested"
+	stateNotSaved      = "error saving state"
+)
+
+// Sink receives the intervals, alarms and summary produced by the pipeline, in order
+type Sink interface {
+	Process(data analytics.ProcessAndOutputData) error
+}
+
+// LineSink is a Sink that also receives every line that's counted in the stats, in order with the
+// other results, e.g. to aggregate a whole file. Lines that are excluded or don't match the filter aren't sent.
+type LineSink interface {
+	Sink
+	ProcessLine(data *parsing.WebServerLogData) error
+}
+
+// countedLine carries a line to the LineSinks on the output channel, other sinks don't see it
+type countedLine struct {
+	data parsing.WebServerLogData
+}
+
+func (countedLine) Do(writer io.Writer) {}
+
+type writerSink struct {
+	writer io.Writer
+}
+
+// WriterSink outputs results as text, the same way the command line tool does
+func WriterSink(writer io.Writer) Sink {
+	return writerSink{writer: writer}
+}
+
+func (ws writerSink) Process(data analytics.ProcessAndOutputData) error {
+	ew := errorWriter{writer: ws.writer}
+	data.Do(&ew)
+	return ew.err
+}
+
+// errorWriter keeps the first write error, since `ProcessAndOutputData.Do` only logs it
+type errorWriter struct {
+	writer io.Writer
+	err    error
+}
+
+func (ew *errorWriter) Write(p []byte) (int, error) {
+	n, err := ew.writer.Write(p)
+	if err != nil && ew.err == nil {
+		ew.err = err
+	}
+	return n, err
+}
+
+// OpenInput opens the input file from config. In follow mode the file is tailed, resuming after the
+// last line processed according to the saved state, if there is one.
+func OpenInput(config manage.Config) (io.ReadCloser, error) {
+	if !config.Follow {
+		return os.Open(config.InputFilepath)
+	}
+
+	checkpoint := checkpoint.Position{}
+	if config.StateFilepath != "" {
+		saved, _, err := state.Load(config.StateFilepath)
+		if err != nil {
+			return nil, err
+		}
+		checkpoint = saved.Checkpoint
+	}
+
+	if config.InputFormat == parsing.FormatCLF {
+		return tailing.OpenHeaderlessFollower(config.InputFilepath, followPollInterval, checkpoint)
+	}
+	return tailing.OpenFollower(config.InputFilepath, followPollInterval, checkpoint)
+}
+
+// Run reads log lines from source and sends results to every sink until the source is
+// exhausted or ctx is cancelled. On cancellation, lines already read are still flushed
+// to the sinks before Run returns. If source is also an io.Closer, it's closed on
+// cancellation so that a blocked read returns, otherwise the read is left running
+// in the background until the source is exhausted and its lines are discarded.
+func Run(ctx context.Context, source io.Reader, config manage.Config, sinks ...Sink) error {
+	return New(config, sinks...).Run(ctx, source)
+}
+
+// Pipeline is the same as `Run`, except its settings can be reloaded while it's running
+type Pipeline struct {
+	config  manage.Config
+	sinks   []Sink
+	reloads chan reloadRequest
+	done    chan struct{}
+}
+
+type reloadRequest struct {
+	config manage.Config
+	result chan error
+}
+
+// New creates a Pipeline that sends results to every sink
+func New(config manage.Config, sinks ...Sink) *Pipeline {
+	return &Pipeline{
+		config:  config,
+		sinks:   sinks,
+		reloads: make(chan reloadRequest),
+		done:    make(chan struct{}),
+	}
+}
+
+// Reload applies new settings to the running pipeline without losing the stats recorded so far, and
+// waits until they're applied. Alarm thresholds, filters, client groups, late data and output settings
+// can be changed, changes to anything else return an error and leave the current settings in place.
+func (pl *Pipeline) Reload(config manage.Config) error {
+	request := reloadRequest{config: config, result: make(chan error, 1)}
+	select {
+	case pl.reloads <- request:
+		return <-request.result
+	case <-pl.done:
+		return errors.New("pipeline isn't running")
+	}
+}
+
+// Run works like the package level `Run`, it can only be called once
+func (pl *Pipeline) Run(ctx context.Context, source io.Reader) error {
+	defer close(pl.done)
+	config := pl.config
+	sinks := pl.sinks
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	inputCh := make(chan parsing.WebServerLogData, inputBufferSize)
+	parseErrCh := make(chan error, 1)
+	go func() {
+		// Note: both parsers close the inputCh when finished
+		if lines, ok := source.(parsing.LineReader); ok {
+			parseErrCh <- parsing.ParseLinesWithFormat(lines, config.InputFormat, inputCh)
+		} else {
+			parseErrCh <- parsing.ParseWithChannel(source, config.InputFormat, inputCh)
+		}
+	}()
+
+	linesCh := inputCh
+	pacingDone := make(chan struct{})
+	if config.ReplaySpeed > 0 {
+		// the rest of the pipeline is unchanged, it just receives the lines at the pace they were logged
+		pacedCh := make(chan parsing.WebServerLogData)
+		go func() {
+			pace(ctx, inputCh, pacedCh, config.ReplaySpeed)
+			close(pacingDone)
+		}()
+		linesCh = pacedCh
+	} else {
+		close(pacingDone)
+	}
+
+	stopReading := func() {
+		if closer, ok := source.(io.Closer); ok {
+			closer.Close()
+		}
+		go func() {
+			for range inputCh { // let the parser exit if it's blocked sending lines we'll never read
+			}
+		}()
+	}
+
+	outputCh := make(chan analytics.ProcessAndOutputData)
+	sinkErrCh := make(chan error, 1)
+	go func() {
+		sinkErrCh <- processOutput(outputCh, sinks, cancel)
+	}()
+
+	emitLines := false
+	for _, sink := range sinks {
+		if _, ok := sink.(LineSink); ok {
+			emitLines = true
+		}
+	}
+
+	inputExhausted, err := processInput(ctx, linesCh, outputCh, pl.reloads, stopReading, config, emitLines)
+	close(outputCh)
+
+	// pacing stops on cancellation, so it doesn't outlive Run
+	cancel()
+	<-pacingDone
+
+	sinkErr := <-sinkErrCh
+	if err == nil {
+		err = sinkErr
+	}
+
+	if inputExhausted {
+		// the parser already finished since inputCh was closed, so this doesn't block
+		if parseErr := <-parseErrCh; parseErr != nil && err == nil {
+			err = fmt.Errorf("error parsing input: %v", parseErr)
+		}
+	}
+
+	return err
+}
+
+// processOutput hands results to the sinks. After the first error it cancels the pipeline, but keeps
+// draining outputCh so that the input side never blocks.
+func processOutput(outputCh chan analytics.ProcessAndOutputData, sinks []Sink, cancel context.CancelFunc) error {
+	var firstErr error
+	for data := range outputCh {
+		if firstErr != nil {
+			continue
+		}
+
+		for _, sink := range sinks {
+			if err := processSink(sink, data); err != nil {
+				firstErr = fmt.Errorf("error writing output: %v", err)
+				cancel()
+				break
+			}
+		}
+	}
+
+	return firstErr
+}
+
+func processSink(sink Sink, data analytics.ProcessAndOutputData) error {
+	line, ok := data.(countedLine)
+	if !ok {
+		return sink.Process(data)
+	}
+
+	if lineSink, ok := sink.(LineSink); ok {
+		return lineSink.ProcessLine(&line.data)
+	}
+	return nil
+}
+
+// processInput runs the main loop, it returns true if it read inputCh until it was closed
+func processInput(
+	ctx context.Context,
+	inputCh chan parsing.WebServerLogData,
+	outputCh chan analytics.ProcessAndOutputData,
+	reloads <-chan reloadRequest,
+	stopReading func(),
+	config manage.Config,
+	emitLines bool,
+) (bool, error) {
+	var buffered []parsing.WebServerLogData
+	var startTime uint64
+	var stopReason string
+	var ticks <-chan time.Time
+	if config.Follow {
+		// in live mode the clock drives the schedule, so there's no need to wait on lines to pick a start time
+		startTime = uint64(now().Unix())
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	} else {
+		buffered, startTime, stopReason = bufferStartup(ctx, inputCh, config.ReorderTolerance)
+	}
+
+	inputExhausted := stopReason == endOfInput
+	if stopReason == shutdownRequested {
+		stopReading()
+	}
+
+	if len(buffered) == 0 && stopReason != "" {
+		outputCh <- analytics.Summary{StopReason: stopReason}
+		return inputExhausted, nil
+	}
+
+	p, err := initProcessor(config, startTime, outputCh)
+	if err != nil {
+		if stopReason == "" {
+			stopReading()
+		}
+		return inputExhausted, err
+	}
+	p.emitLines = emitLines
+
+	var saves <-chan time.Time
+	if config.StateFilepath != "" {
+		if err := p.restoreState(); err != nil {
+			if stopReason == "" {
+				stopReading()
+			}
+			return inputExhausted, fmt.Errorf("error restoring state: %v", err)
+		}
+
+		saveTicker := time.NewTicker(time.Duration(config.StateSaveInterval) * time.Second)
+		defer saveTicker.Stop()
+		saves = saveTicker.C
+	}
+
+	for _, data := range buffered {
+		p.process(data)
+	}
+
+	var saveErr error
+	for stopReason == "" {
+		select {
+		case <-ctx.Done():
+			stopReading()
+			stopReason = shutdownRequested
+		case <-saves:
+			if saveErr = p.saveState(); saveErr != nil {
+				stopReading()
+				stopReason = stateNotSaved
+			}
+		case <-ticks:
+			p.tick(uint64(now().Unix()))
+		case request := <-reloads:
+			request.result <- p.reload(request.config)
+		case data, ok := <-inputCh:
+			if !ok {
+				inputExhausted = true
+				stopReason = endOfInput
+				continue
+			}
+			p.process(data)
+		}
+	}
+
+	p.flush(stopReason)
+	if config.StateFilepath != "" && saveErr == nil {
+		saveErr = p.saveState()
+	}
+	if saveErr != nil {
+		return inputExhausted, fmt.Errorf("error saving state: %v", saveErr)
+	}
+
+	return inputExhausted, nil
+}
+
+// bufferStartup reads lines until they span the reorder tolerance. Log lines aren't guaranteed to be
+// in order, so the earliest time seen is used to start from rather than the first line.
+// The stop reason is empty if there is still input to read.
+func bufferStartup(ctx context.Context, inputCh chan parsing.WebServerLogData, reorderTolerance uint64) ([]parsing.WebServerLogData, uint64, string) {
+	buffered := []parsing.WebServerLogData{}
+	earliestTime := uint64(0)
+	latestTime := uint64(0)
+
+	for len(buffered) == 0 || latestTime-earliestTime < reorderTolerance {
+		select {
+		case <-ctx.Done():
+			return buffered, earliestTime, shutdownRequested
+		case data, ok := <-inputCh:
+			if !ok {
+				return buffered, earliestTime, endOfInput
+			}
+
+			if len(buffered) == 0 || data.Date < earliestTime {
+				earliestTime = data.Date
+			}
+			if data.Date > latestTime {
+				latestTime = data.Date
+			}
+			buffered = append(buffered, data)
+		}
+	}
+
+	return buffered, earliestTime, ""
+}
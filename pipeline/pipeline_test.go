@@ -0,0 +1,478 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/manage"
+	"github.com/hardboiled/apache-log-parser/parsing"
+	"github.com/hardboiled/apache-log-parser/pipeline"
+	"github.com/hardboiled/apache-log-parser/webstats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPipeline(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Pipeline Suite")
+}
+
+const header = `"remotehost","rfc931","authuser","date","request","status","bytes"` + "\n"
+
+func logLines(startTime uint64, seconds int) string {
+	lines := []string{}
+	for i := 0; i < seconds; i++ {
+		lines = append(lines, fmt.Sprintf(`"10.0.0.1","-","apache",%d,"GET /api/user HTTP/1.0",200,1234`, startTime+uint64(i)))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+type collectSink struct {
+	results chan analytics.ProcessAndOutputData
+	err     error
+}
+
+func (cs *collectSink) Process(data analytics.ProcessAndOutputData) error {
+	cs.results <- data
+	return cs.err
+}
+
+type lineCollectSink struct {
+	collectSink
+	lines []string
+}
+
+func (lcs *lineCollectSink) ProcessLine(data *parsing.WebServerLogData) error {
+	lcs.lines = append(lcs.lines, data.RemoteHost)
+	return nil
+}
+
+// signalLineSink signals every counted line, so a test can wait for lines to be processed
+type signalLineSink struct {
+	lines chan struct{}
+}
+
+func newSignalLineSink() *signalLineSink {
+	return &signalLineSink{lines: make(chan struct{}, 1000)}
+}
+
+func (sls *signalLineSink) Process(data analytics.ProcessAndOutputData) error {
+	return nil
+}
+
+func (sls *signalLineSink) ProcessLine(data *parsing.WebServerLogData) error {
+	sls.lines <- struct{}{}
+	return nil
+}
+
+func (sls *signalLineSink) waitFor(numLines int) {
+	for i := 0; i < numLines; i++ {
+		Eventually(sls.lines, 5*time.Second).Should(Receive())
+	}
+}
+
+func collected(cs *collectSink) []analytics.ProcessAndOutputData {
+	close(cs.results)
+	results := []analytics.ProcessAndOutputData{}
+	for v := range cs.results {
+		results = append(results, v)
+	}
+	return results
+}
+
+var _ = Describe("Run", func() {
+	var config manage.Config
+	var sink *collectSink
+	startTime := uint64(1549573860)
+
+	BeforeEach(func() {
+		config = manage.Config{Interval: 10, ReorderTolerance: analytics.BufferForOverlappingLogTimes, WindowSize: 240, AlarmThreshold: 10, TopClients: 3}
+		sink = &collectSink{results: make(chan analytics.ProcessAndOutputData, 100)}
+	})
+
+	It("outputs intervals and a summary for the whole input", func() {
+		err := pipeline.Run(context.Background(), strings.NewReader(header+logLines(startTime, 30)), config, sink)
+		Expect(err).To(BeNil())
+
+		results := collected(sink)
+		Expect(len(results)).To(BeNumerically(">", 2))
+		for _, v := range results[:len(results)-1] {
+			Expect(v).To(BeAssignableToTypeOf(&analytics.SectionData{}))
+		}
+
+		summary := results[len(results)-1].(analytics.Summary)
+		Expect(summary.StopReason).To(Equal("end of input"))
+		Expect(summary.LinesRead).To(Equal(uint64(30)))
+		Expect(summary.LatestTime).To(Equal(startTime + 29))
+	})
+
+	It("counts requests without a path, like apache's 408 timeouts", func() {
+		config.InputFormat = parsing.FormatCLF
+		input := `10.0.0.1 - - [07/Feb/2019:21:11:00 +0000] "-" 408 -` + "\n" +
+			`10.0.0.1 - - [07/Feb/2019:21:11:00 +0000] "GET /api/user HTTP/1.0" 200 1234` + "\n"
+		lineSink := &lineCollectSink{collectSink: collectSink{results: make(chan analytics.ProcessAndOutputData, 100)}}
+		err := pipeline.Run(context.Background(), strings.NewReader(input), config, sink, lineSink)
+		Expect(err).To(BeNil())
+
+		results := collected(sink)
+		sections := map[string]uint64{}
+		for _, slot := range results[0].(*analytics.SectionData).Window {
+			for k, v := range slot.Sections {
+				sections[k] += v
+			}
+		}
+		Expect(sections).To(Equal(map[string]uint64{parsing.NoSection: 1, "/api": 1}))
+		summary := results[len(results)-1].(analytics.Summary)
+		Expect(summary.LinesCounted).To(Equal(uint64(2)))
+		Expect(lineSink.lines).To(HaveLen(2))
+	})
+
+	It("reads intervals the window can't hold from a rollup, aligned to its periods", func() {
+		config.Interval = 600
+		config.Rollups, _ = webstats.ParseRollups(webstats.DefaultRollups)
+		err := pipeline.Run(context.Background(), strings.NewReader(header+logLines(startTime, 1500)), config, sink)
+		Expect(err).To(BeNil())
+
+		results := collected(sink)
+		Expect(results).To(HaveLen(4))
+		hits := []uint64{}
+		for _, v := range results[:3] {
+			sd := v.(*analytics.SectionData)
+			Expect(sd.Resolution).To(Equal(uint64(60)))
+			Expect(sd.Start() % 60).To(Equal(uint64(0)))
+			total := uint64(0)
+			for _, slot := range sd.Window {
+				total += slot.TotalHitsForTimeSlot
+			}
+			hits = append(hits, total)
+		}
+		// the first interval starts at the minute before the reorder tolerance, startTime is a minute
+		Expect(hits).To(Equal([]uint64{540, 600, 360}))
+		Expect(results[0].(*analytics.SectionData).Start()).To(Equal(startTime - 60))
+	})
+
+	It("only reports counted lines as arriving after their interval", func() {
+		config.ExcludeCIDRs = []string{"10.0.0.9/32"}
+		late := fmt.Sprintf(`"10.0.0.9","-","apache",%d,"GET /api/user HTTP/1.0",200,1234`+"\n", startTime+1)
+		input := header + logLines(startTime, 30) + late + logLines(startTime+1, 1)
+		err := pipeline.Run(context.Background(), strings.NewReader(input), config, sink)
+		Expect(err).To(BeNil())
+
+		results := collected(sink)
+		summary := results[len(results)-1].(analytics.Summary)
+		Expect(summary.LinesCounted).To(Equal(uint64(31)))
+		Expect(summary.ReorderedLines).To(Equal(uint64(1)))
+	})
+
+	It("outputs a no data summary when there are no lines", func() {
+		err := pipeline.Run(context.Background(), strings.NewReader(header), config, sink)
+		Expect(err).To(BeNil())
+		err = pipeline.Run(context.Background(), strings.NewReader(""), config, sink)
+		Expect(err).To(BeNil())
+
+		results := collected(sink)
+		noData := analytics.Summary{StopReason: "end of input"}
+		Expect(results).To(Equal([]analytics.ProcessAndOutputData{noData, noData}))
+	})
+
+	It("starts from the earliest time when the first lines are out of order", func() {
+		input := header + logLines(startTime+3, 1) + logLines(startTime, 1) + logLines(startTime+1, 30)
+		err := pipeline.Run(context.Background(), strings.NewReader(input), config, sink)
+		Expect(err).To(BeNil())
+
+		results := collected(sink)
+		firstInterval := results[0].(*analytics.SectionData)
+		totalHits := uint64(0)
+		for _, v := range firstInterval.Window {
+			totalHits += v.TotalHitsForTimeSlot
+		}
+		Expect(totalHits).To(Equal(uint64(7))) // the first interval starts `BufferForOverlappingLogTimes` before the earliest line
+
+		summary := results[len(results)-1].(analytics.Summary)
+		Expect(summary.FirstTime).To(Equal(startTime))
+		Expect(summary.LinesRead).To(Equal(uint64(32)))
+		Expect(summary.LinesCounted).To(Equal(uint64(32)))
+	})
+
+	It("flushes what was read when cancelled", func() {
+		reader, writer := io.Pipe()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- pipeline.Run(ctx, reader, config, sink)
+		}()
+
+		_, err := writer.Write([]byte(header + logLines(startTime, 20)))
+		Expect(err).To(BeNil())
+		// the first interval shows the lines are being processed, the writer is left open like a live log
+		Eventually(sink.results).Should(Receive())
+		cancel()
+
+		Eventually(done).Should(Receive(BeNil()))
+		results := collected(sink)
+		summary := results[len(results)-1].(analytics.Summary)
+		Expect(summary.StopReason).To(Equal("shutdown requested"))
+	})
+
+	It("returns the first sink error", func() {
+		sink.err = errors.New("disk full")
+		err := pipeline.Run(context.Background(), strings.NewReader(header+logLines(startTime, 30)), config, sink)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("disk full"))
+		Expect(len(collected(sink))).To(Equal(1))
+	})
+
+	It("sends counted lines only to line sinks", func() {
+		config.ExcludeCIDRs = []string{"10.0.5.0/24"}
+		excluded := strings.Replace(logLines(startTime+10, 5), "10.0.0.1", "10.0.5.1", -1)
+		lineSink := &lineCollectSink{collectSink: collectSink{results: make(chan analytics.ProcessAndOutputData, 100)}}
+		err := pipeline.Run(context.Background(), strings.NewReader(header+logLines(startTime, 10)+excluded), config, sink, lineSink)
+		Expect(err).To(BeNil())
+
+		Expect(lineSink.lines).To(HaveLen(10))
+		for _, v := range lineSink.lines {
+			Expect(v).To(Equal("10.0.0.1"))
+		}
+		Expect(collected(&lineSink.collectSink)).To(Equal(collected(sink)))
+	})
+
+	It("paces the input by its log times when replaying", func() {
+		config.ReplaySpeed = 100 // 30 seconds of log in 0.3s
+		begin := time.Now()
+		err := pipeline.Run(context.Background(), strings.NewReader(header+logLines(startTime, 31)), config, sink)
+		Expect(err).To(BeNil())
+		Expect(time.Since(begin)).To(BeNumerically(">=", 300*time.Millisecond))
+
+		results := collected(sink)
+		summary := results[len(results)-1].(analytics.Summary)
+		Expect(summary.StopReason).To(Equal("end of input"))
+		Expect(summary.LinesRead).To(Equal(uint64(31)))
+	})
+
+	It("stops replaying when cancelled", func() {
+		// replay reads the clock once it starts pacing the lines
+		started := make(chan struct{})
+		var once sync.Once
+		restore := pipeline.SetClock(func() time.Time {
+			once.Do(func() { close(started) })
+			return time.Now()
+		}, time.Second)
+		defer restore()
+
+		config.ReplaySpeed = 1
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- pipeline.Run(ctx, strings.NewReader(header+logLines(startTime, 600)), config, sink)
+		}()
+
+		Eventually(started).Should(BeClosed())
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+
+		results := collected(sink)
+		summary := results[len(results)-1].(analytics.Summary)
+		Expect(summary.StopReason).To(Equal("shutdown requested"))
+		Expect(summary.LinesRead).To(BeNumerically("<", 600))
+	})
+
+	It("outputs empty intervals and alarm recoveries from the clock in live mode", func() {
+		var mu sync.Mutex
+		clockTime := time.Unix(int64(startTime), 0)
+		restore := pipeline.SetClock(func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			clockTime = clockTime.Add(time.Second) // every tick is a second later
+			return clockTime
+		}, time.Millisecond)
+		defer restore()
+
+		config.Follow = true
+		config.AlarmThreshold = 1
+		reader, writer := io.Pipe()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- pipeline.Run(ctx, reader, config, sink)
+		}()
+
+		lines := strings.Repeat(logLines(startTime+1, 1), 150)
+		_, err := writer.Write([]byte(header + lines))
+		Expect(err).To(BeNil())
+
+		alarms := []analytics.TotalHitsAlarm{}
+		emptyIntervals := 0
+		for len(alarms) < 2 {
+			var result analytics.ProcessAndOutputData
+			Eventually(sink.results).Should(Receive(&result))
+			switch v := result.(type) {
+			case analytics.TotalHitsAlarm:
+				alarms = append(alarms, v)
+			case *analytics.SectionData:
+				if len(alarms) == 1 && v.Window[len(v.Window)-1].TotalHitsForTimeSlot == 0 {
+					emptyIntervals++
+				}
+			}
+		}
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+
+		Expect(alarms[0].Flag).To(Equal(true))
+		Expect(alarms[1].Flag).To(Equal(false))
+		Expect(emptyIntervals).To(BeNumerically(">", 5))
+	})
+
+	It("resumes from the saved state after a restart", func() {
+		dir, err := ioutil.TempDir("", "pipeline")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		restore := pipeline.SetClock(func() time.Time { return time.Unix(int64(startTime+1), 0) }, time.Millisecond)
+		defer restore()
+
+		config.Follow = true
+		config.AlarmThreshold = 1
+		config.StateFilepath = filepath.Join(dir, "state.json")
+		config.StateSaveInterval = 60
+
+		runUntil := func(numLines int, stopAfter func(analytics.ProcessAndOutputData) bool) []analytics.ProcessAndOutputData {
+			sink := &collectSink{results: make(chan analytics.ProcessAndOutputData, 100)}
+			lines := newSignalLineSink()
+			reader, writer := io.Pipe()
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- pipeline.Run(ctx, reader, config, sink, lines)
+			}()
+			go writer.Write([]byte(header + strings.Repeat(logLines(startTime+1, 1), numLines)))
+
+			results := []analytics.ProcessAndOutputData{}
+			for stopAfter != nil {
+				var result analytics.ProcessAndOutputData
+				Eventually(sink.results).Should(Receive(&result))
+				results = append(results, result)
+				if stopAfter(result) {
+					break
+				}
+			}
+			lines.waitFor(numLines) // so they're all in the saved state
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+			return append(results, collected(sink)...)
+		}
+
+		isAlarm := func(result analytics.ProcessAndOutputData) bool {
+			_, ok := result.(analytics.TotalHitsAlarm)
+			return ok
+		}
+		runUntil(150, isAlarm)
+
+		results := runUntil(0, nil)
+		for _, v := range results {
+			Expect(isAlarm(v)).To(Equal(false))
+		}
+
+		summary := results[len(results)-1].(analytics.Summary)
+		Expect(summary.TotalHitsAlarm).To(Equal(true))
+		Expect(summary.LinesRead).To(Equal(uint64(150)))
+	})
+
+	It("resumes following a file after the last line processed", func() {
+		dir, err := ioutil.TempDir("", "pipeline")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		restore := pipeline.SetClock(func() time.Time { return time.Unix(int64(startTime+1), 0) }, time.Millisecond)
+		defer restore()
+
+		config.Follow = true
+		config.InputFilepath = filepath.Join(dir, "access.log")
+		config.StateFilepath = filepath.Join(dir, "state.json")
+		config.StateSaveInterval = 60
+
+		appendLines := func(lines string) {
+			file, err := os.OpenFile(config.InputFilepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			Expect(err).To(BeNil())
+			defer file.Close()
+			_, err = file.WriteString(lines)
+			Expect(err).To(BeNil())
+		}
+
+		// run follows the file until numLines have been processed
+		run := func(numLines int, whileRunning func()) analytics.Summary {
+			sink := &collectSink{results: make(chan analytics.ProcessAndOutputData, 100)}
+			lines := newSignalLineSink()
+			reader, err := pipeline.OpenInput(config)
+			Expect(err).To(BeNil())
+			defer reader.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- pipeline.Run(ctx, reader, config, sink, lines)
+			}()
+			whileRunning()
+			lines.waitFor(numLines)
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+
+			results := collected(sink)
+			return results[len(results)-1].(analytics.Summary)
+		}
+
+		appendLines(header + logLines(startTime, 3)) // already in the file before the first start, so skipped
+		summary := run(5, func() { appendLines(logLines(startTime+1, 5)) })
+		Expect(summary.LinesRead).To(Equal(uint64(5)))
+
+		appendLines(logLines(startTime+1, 4)) // written while stopped
+		summary = run(4, func() {})
+		Expect(summary.LinesRead).To(Equal(uint64(9)))
+	})
+
+	It("applies reloaded thresholds without losing the window", func() {
+		restore := pipeline.SetClock(func() time.Time { return time.Unix(int64(startTime+1), 0) }, time.Millisecond)
+		defer restore()
+
+		config.Follow = true
+		config.AlarmThreshold = 1
+		p := pipeline.New(config, sink)
+		reader, writer := io.Pipe()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- p.Run(ctx, reader)
+		}()
+		go writer.Write([]byte(header + strings.Repeat(logLines(startTime+1, 1), 150)))
+
+		var result analytics.ProcessAndOutputData
+		Eventually(sink.results).Should(Receive(&result))
+		Expect(result).To(Equal(analytics.TotalHitsAlarm{Flag: true, Hits: 121, CurrentTime: startTime + 1}))
+
+		tooBig := config
+		tooBig.WindowSize = 480
+		Expect(p.Reload(tooBig)).ToNot(BeNil())
+
+		raised := config
+		raised.AlarmThreshold = 10
+		Expect(p.Reload(raised)).To(BeNil())
+		Eventually(sink.results).Should(Receive(&result))
+		Expect(result).To(BeAssignableToTypeOf(analytics.ConfigReloaded{}))
+		Eventually(sink.results).Should(Receive(&result))
+		Expect(result.(analytics.TotalHitsAlarm).Flag).To(Equal(false))
+		Expect(result.(analytics.TotalHitsAlarm).Hits).To(BeNumerically(">", 120)) // the window was kept
+
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(p.Reload(raised)).ToNot(BeNil())
+	})
+})
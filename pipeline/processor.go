@@ -0,0 +1,378 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/checkpoint"
+	"github.com/hardboiled/apache-log-parser/clients"
+	"github.com/hardboiled/apache-log-parser/filter"
+	"github.com/hardboiled/apache-log-parser/manage"
+	"github.com/hardboiled/apache-log-parser/parsing"
+	"github.com/hardboiled/apache-log-parser/state"
+	"github.com/hardboiled/apache-log-parser/webstats"
+)
+
+// processor holds the state of the main loop, it's only used from a single go routine
+type processor struct {
+	config           manage.Config
+	webStats         *webstats.WebStats
+	scheduleInterval analytics.ScheduleInterval
+	classifier       clients.Classifier
+	lineFilter       filter.Filter
+	lateDataPolicy   webstats.LateDataPolicy
+	resolution       uint64 // seconds per entry of the intervals, more than 1 when they're read from a rollup
+	outputCh         chan<- analytics.ProcessAndOutputData
+	firstTime        uint64
+	linesRead        uint64
+	linesCounted     uint64
+	lastOffenders    []webstats.ClientHits
+	checkpoint       checkpoint.Position // end of the last line processed when following a file
+	emitLines        bool                // send counted lines to the LineSinks
+}
+
+func initProcessor(config manage.Config, startTime uint64, outputCh chan<- analytics.ProcessAndOutputData) (*processor, error) {
+	webStats, err := webstats.InitWebStats(config.WindowSize, config.AlarmThreshold, startTime)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing webstats: %v", err)
+	}
+	if err := webStats.SetRollups(config.Rollups); err != nil {
+		return nil, fmt.Errorf("error initializing rollups: %v", err)
+	}
+
+	scheduleInterval, err := analytics.InitScheduleInterval(startTime, config.Interval, config.ReorderTolerance)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing scheduleInterval: %v", err)
+	}
+
+	// intervals that the window can't hold twice are read from a rollup, so they have to line up with its periods
+	resolution := uint64(1)
+	if config.Interval*2 > uint64(config.WindowSize) {
+		rollup, ok := webstats.RollupForInterval(config.Rollups, config.Interval)
+		if !ok {
+			return nil, fmt.Errorf("error initializing scheduleInterval: no rollup can hold an interval of %d seconds", config.Interval)
+		}
+		resolution = uint64(rollup.Resolution / time.Second)
+		if err := scheduleInterval.AlignTo(resolution); err != nil {
+			return nil, fmt.Errorf("error initializing scheduleInterval: %v", err)
+		}
+	}
+
+	p := &processor{
+		config:           config,
+		webStats:         webStats,
+		scheduleInterval: scheduleInterval,
+		resolution:       resolution,
+		outputCh:         outputCh,
+		firstTime:        startTime,
+		lastOffenders:    []webstats.ClientHits{},
+	}
+	if err := p.applySettings(config); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// applySettings sets up everything that can be changed while running, see `reload`
+func (p *processor) applySettings(config manage.Config) error {
+	// parse everything first, so that an invalid setting leaves the current ones in place
+	lateDataPolicy, err := webstats.ParseLateDataPolicy(config.LateData)
+	if err != nil {
+		return fmt.Errorf("error initializing late data policy: %v", err)
+	}
+
+	classifier, err := clients.InitClassifier(config.ClientGroups, config.ExcludeCIDRs)
+	if err != nil {
+		return fmt.Errorf("error initializing client groups: %v", err)
+	}
+
+	lineFilter, err := filter.Compile(config.Filter)
+	if err != nil {
+		return fmt.Errorf("error initializing filter: %v", err)
+	}
+
+	if err := p.webStats.SetTotalTrafficThreshold(config.AlarmThreshold); err != nil {
+		return fmt.Errorf("error initializing alarm threshold: %v", err)
+	}
+
+	if config.LatencyThreshold > 0 {
+		err = p.webStats.EnableLatencyAlarm(uint64(config.LatencyThreshold)*1000, config.LatencySlowPercent)
+		if err != nil {
+			return fmt.Errorf("error initializing latency alarm: %v", err)
+		}
+	} else {
+		p.webStats.DisableLatencyAlarm()
+	}
+
+	if config.RateLimitRequests > 0 {
+		err = p.webStats.EnableRateLimit(uint64(config.RateLimitRequests), uint64(config.RateLimitSeconds))
+		if err != nil {
+			return fmt.Errorf("error initializing rate limit: %v", err)
+		}
+	} else {
+		p.webStats.DisableRateLimit()
+	}
+
+	p.webStats.SetMaxSections(int(config.MaxSections))
+	p.webStats.SetLateDataPolicy(lateDataPolicy)
+	p.lateDataPolicy = lateDataPolicy
+	p.classifier = classifier
+	p.lineFilter = lineFilter
+	return nil
+}
+
+// reload applies new thresholds, filters and output settings without losing the recorded stats.
+// Settings that decide how stats are kept or where the input comes from need a restart.
+func (p *processor) reload(config manage.Config) error {
+	if changed := restartOnlyChanges(p.config, config); len(changed) > 0 {
+		return fmt.Errorf("%s can't be changed without a restart", strings.Join(changed, ", "))
+	}
+
+	before := p.alarmState()
+	if err := p.applySettings(config); err != nil {
+		return err
+	}
+	p.config = config
+
+	p.emit(analytics.ConfigReloaded{CurrentTime: p.webStats.LatestTime()})
+	p.emitAlarmChanges(before) // e.g. a higher threshold recovers the alarm right away
+	return nil
+}
+
+func restartOnlyChanges(current, next manage.Config) []string {
+	changed := []string{}
+	for _, v := range []struct {
+		name    string
+		changed bool
+	}{
+		{"interval", current.Interval != next.Interval},
+		{"window-retention", current.WindowSize != next.WindowSize},
+		{"rollups", !reflect.DeepEqual(current.Rollups, next.Rollups)},
+		{"reorder-tolerance", current.ReorderTolerance != next.ReorderTolerance},
+		{"input-filepath", current.InputFilepath != next.InputFilepath},
+		{"input-format", current.InputFormat != next.InputFormat},
+		{"follow", current.Follow != next.Follow},
+		{"replay-speed", current.ReplaySpeed != next.ReplaySpeed},
+		{"state-filepath", current.StateFilepath != next.StateFilepath},
+		{"state-save-interval", current.StateSaveInterval != next.StateSaveInterval},
+		{"export-csv", current.ExportCSV != next.ExportCSV},
+		{"export-parquet", current.ExportParquet != next.ExportParquet},
+	} {
+		if v.changed {
+			changed = append(changed, v.name)
+		}
+	}
+
+	return changed
+}
+
+func (p *processor) emit(data analytics.ProcessAndOutputData) {
+	p.outputCh <- data
+}
+
+func (p *processor) shouldCount(data *parsing.WebServerLogData) bool {
+	return !p.classifier.IsExcluded(data.RemoteHost) && p.lineFilter.Match(data)
+}
+
+func (p *processor) statsEntry(data *parsing.WebServerLogData) webstats.Entry {
+	return webstats.Entry{
+		Section:     data.RequestSection(),
+		RemoteHost:  data.RemoteHost,
+		ClientGroup: p.classifier.Label(data.RemoteHost),
+		Time:        data.Date,
+		Latency:     data.Latency,
+		Status:      data.Status,
+		Bytes:       data.Bytes,
+	}
+}
+
+func (p *processor) sectionData(latestTime, secondsAgo uint64) *analytics.SectionData {
+	if p.resolution > 1 {
+		// intervals line up with the periods, except the last one in flush, which is rounded out to whole periods
+		resolution := time.Duration(p.resolution) * time.Second
+		window, err := p.webStats.GetRollupForRange(resolution, latestTime-secondsAgo, latestTime)
+		if err == nil {
+			return &analytics.SectionData{
+				LatestTime:    (latestTime/p.resolution+1)*p.resolution - 1,
+				Window:        window,
+				NumTopClients: int(p.config.TopClients),
+				Resolution:    p.resolution,
+			}
+		}
+	}
+
+	if windowSize := uint64(p.webStats.WindowSize()); secondsAgo >= windowSize {
+		secondsAgo = windowSize - 1 // older seconds aren't retained
+	}
+
+	return &analytics.SectionData{
+		LatestTime:    latestTime,
+		Window:        p.webStats.GetWindowForRange(latestTime, secondsAgo),
+		NumTopClients: int(p.config.TopClients),
+	}
+}
+
+func (p *processor) process(data parsing.WebServerLogData) {
+	p.linesRead++
+	if data.Position != (checkpoint.Position{}) {
+		p.checkpoint = data.Position
+	}
+
+	// Check to print interval, unless the clock drives the schedule in live mode
+	if !p.config.Follow {
+		p.processIntervalIfReady(data.Date)
+	}
+
+	if !p.shouldCount(&data) {
+		return
+	}
+	p.linesCounted++
+	p.scheduleInterval.CountIfLate(data.Date)
+
+	before := p.alarmState()
+	p.webStats.Record(p.statsEntry(&data))
+	if p.emitLines {
+		p.emit(countedLine{data: data})
+	}
+	p.emitAlarmChanges(before)
+}
+
+// tick is called every second in live mode, so that intervals and alarm recoveries are output
+// even when no lines come in
+func (p *processor) tick(now uint64) {
+	before := p.alarmState()
+	p.webStats.AdvanceTo(now)
+	p.emitAlarmChanges(before)
+
+	p.processIntervalIfReady(now)
+}
+
+func (p *processor) processIntervalIfReady(date uint64) {
+	if p.scheduleInterval.ReadyToProcess(date) {
+		p.emit(p.sectionData(p.scheduleInterval.TimeToProcess(), p.scheduleInterval.SecondsAgo()))
+		p.scheduleInterval.MarkAsProcessed()
+	}
+}
+
+type alarmState struct {
+	totalTraffic bool
+	latency      bool
+	latestTime   uint64
+}
+
+func (p *processor) alarmState() alarmState {
+	return alarmState{
+		totalTraffic: p.webStats.HasTotalTrafficAlarm(),
+		latency:      p.webStats.HasLatencyAlarm(),
+		latestTime:   p.webStats.LatestTime(),
+	}
+}
+
+// emitAlarmChanges compares alarm state from before the stats were updated, if different, print alarm status
+func (p *processor) emitAlarmChanges(before alarmState) {
+	curAlarm := p.webStats.HasTotalTrafficAlarm()
+	if before.totalTraffic != curAlarm {
+		p.emit(analytics.TotalHitsAlarm{Flag: curAlarm, Hits: p.webStats.TotalHitsForLast2Min(), CurrentTime: p.webStats.LatestTime()})
+	}
+
+	curLatencyAlarm := p.webStats.HasLatencyAlarm()
+	if before.latency != curLatencyAlarm {
+		p.emit(analytics.LatencyAlarm{
+			Flag:        curLatencyAlarm,
+			SlowHits:    p.webStats.SlowHitsForLast2Min(),
+			Hits:        p.webStats.TotalHitsForLast2Min(),
+			CurrentTime: p.webStats.LatestTime(),
+		})
+	}
+
+	// Rate limits are checked once the previous second is complete, and only reported when there are new offenders
+	if lastTime := before.latestTime; lastTime < p.webStats.LatestTime() {
+		offenders := p.webStats.RateLimitOffenders(lastTime)
+		if hasNewOffenders(p.lastOffenders, offenders) {
+			limit, seconds := p.webStats.RateLimit()
+			p.emit(analytics.RateLimitViolation{Offenders: offenders, Limit: limit, Seconds: seconds, CurrentTime: lastTime})
+		}
+		p.lastOffenders = offenders
+	}
+}
+
+// flush outputs the remaining results and the summary
+func (p *processor) flush(stopReason string) {
+	// start with an interval that was scheduled but still waiting on late lines
+	if p.scheduleInterval.IsScheduled() {
+		p.emit(p.sectionData(p.scheduleInterval.TimeToProcess(), p.scheduleInterval.SecondsAgo()))
+		p.scheduleInterval.MarkAsProcessed()
+	}
+
+	if p.scheduleInterval.LastTimeProcessed() < p.webStats.LatestTime() {
+		numSecondsLeft := p.webStats.LatestTime() - p.scheduleInterval.LastTimeProcessed()
+		if numSecondsLeft > p.config.Interval {
+			numSecondsLeft = p.config.Interval // if lastTimeProcessed was greater than the interval, only process the last interval
+		}
+
+		p.emit(p.sectionData(p.webStats.LatestTime(), numSecondsLeft-1))
+	}
+
+	p.emit(analytics.Summary{
+		StopReason:     stopReason,
+		LinesRead:      p.linesRead,
+		LinesCounted:   p.linesCounted,
+		FirstTime:      p.firstTime,
+		LatestTime:     p.webStats.LatestTime(),
+		TotalHitsAlarm: p.webStats.HasTotalTrafficAlarm(),
+		LatencyAlarm:   p.webStats.HasLatencyAlarm(),
+		LateLines:      p.webStats.LateEntries(),
+		ReorderedLines: p.scheduleInterval.LateLines(),
+		LateDataPolicy: p.lateDataPolicy.String(),
+		Hits:           p.webStats.TotalHitsForLast2Min(),
+		SlowHits:       p.webStats.SlowHitsForLast2Min(),
+	})
+}
+
+// restoreState continues from the state saved by a previous run, if there is one
+func (p *processor) restoreState() error {
+	saved, ok, err := state.Load(p.config.StateFilepath)
+	if err != nil || !ok {
+		return err
+	}
+
+	if err := p.webStats.Restore(saved.WebStats); err != nil {
+		return err
+	}
+	p.scheduleInterval.Restore(saved.Schedule)
+	p.firstTime = saved.FirstTime
+	p.linesRead = saved.LinesRead
+	p.linesCounted = saved.LinesCounted
+	p.checkpoint = saved.Checkpoint
+	return nil
+}
+
+func (p *processor) saveState() error {
+	return state.Save(p.config.StateFilepath, state.State{
+		WebStats:     p.webStats.Snapshot(),
+		Schedule:     p.scheduleInterval.State(),
+		FirstTime:    p.firstTime,
+		LinesRead:    p.linesRead,
+		LinesCounted: p.linesCounted,
+		Checkpoint:   p.checkpoint,
+	})
+}
+
+func hasNewOffenders(previous, current []webstats.ClientHits) bool {
+	seen := map[string]bool{}
+	for _, v := range previous {
+		seen[v.RemoteHost] = true
+	}
+
+	for _, v := range current {
+		if !seen[v.RemoteHost] {
+			return true
+		}
+	}
+
+	return false
+}
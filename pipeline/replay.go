@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/parsing"
+)
+
+// pace forwards lines from in to out no faster than their log times allow at the given speed,
+// e.g. at speed 10 lines 60s apart in the log are sent 6s apart. Lines that are out of order
+// are sent right away, since the line they're behind already waited for their time.
+// out is only closed once in is, on cancellation it's left open for `Run` to stop reading.
+func pace(ctx context.Context, in <-chan parsing.WebServerLogData, out chan<- parsing.WebServerLogData, speed float64) {
+	var startedAt time.Time
+	var firstTime, latestTime uint64
+	for {
+		var data parsing.WebServerLogData
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case data, ok = <-in:
+		}
+		if !ok {
+			break
+		}
+
+		if startedAt.IsZero() {
+			startedAt = now()
+			firstTime = data.Date
+			latestTime = data.Date
+		}
+
+		if data.Date > latestTime {
+			latestTime = data.Date
+			due := startedAt.Add(time.Duration(float64(latestTime-firstTime) / speed * float64(time.Second)))
+			if wait := due.Sub(now()); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case out <- data:
+		}
+	}
+
+	close(out)
+}
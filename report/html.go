@@ -0,0 +1,162 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+const (
+	chartWidth     = 960
+	chartHeight    = 240
+	maxChartPoints = chartWidth // more than one point per pixel wouldn't show
+)
+
+// chart is a step chart of the hits per second, with the alarm episodes shaded. Long reports are
+// bucketed so there's at most one step per pixel, and each step is the average hits per second.
+type chart struct {
+	Width         int
+	Height        int
+	Points        string
+	Shades        []shade
+	MaxHits       float64
+	BucketSeconds uint64
+}
+
+type shade struct {
+	X     float64
+	Width float64
+	Class string
+	Title string
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":     formatTime,
+	"minute":   formatMinute,
+	"duration": episodeDuration,
+	"peak":     peakText,
+	"chart":    newChart,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Report {{time .FirstTime}} - {{time .LatestTime}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+td.hits { text-align: right; }
+.section { display: inline-block; vertical-align: top; margin-right: 2em; }
+svg { border: 1px solid #ccc; }
+svg text { font-size: 12px; fill: #555; }
+.hits { fill: none; stroke: #1f77b4; stroke-width: 1; }
+rect.high-traffic { fill: #d62728; fill-opacity: 0.2; }
+rect.high-latency { fill: #ff7f0e; fill-opacity: 0.2; }
+</style>
+</head>
+<body>
+<h1>Report</h1>
+<p>Stopped after {{.StopReason}}</p>
+{{if eq .LinesRead 0}}<p>No log lines were read</p>{{else}}
+<table>
+<tr><th>Time range</th><td>{{time .FirstTime}} - {{time .LatestTime}}</td></tr>
+<tr><th>Lines read</th><td class="hits">{{.LinesRead}}</td></tr>
+<tr><th>Lines counted</th><td class="hits">{{.LinesCounted}}</td></tr>
+<tr><th>Total hits</th><td class="hits">{{.Hits}}</td></tr>
+<tr><th>Total bytes</th><td class="hits">{{.Bytes}}</td></tr>
+</table>
+
+<h2>Hits per second</h2>
+{{with $chart := chart .}}
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+{{range .Shades}}<rect class="{{.Class}}" x="{{printf "%.1f" .X}}" y="0" width="{{printf "%.1f" .Width}}" height="{{$chart.Height}}"><title>{{.Title}}</title></rect>
+{{end}}<polyline class="hits" points="{{.Points}}"/>
+<text x="4" y="14">{{printf "%.1f" .MaxHits}} hits/s</text>
+</svg>
+{{if gt .BucketSeconds 1}}<p>Averaged over {{.BucketSeconds}} seconds</p>{{end}}
+{{end}}
+<p>{{time .FirstTime}} - {{time .LatestTime}}</p>
+
+<h2>Alarm episodes</h2>
+{{if .Episodes}}<table>
+<tr><th>Alarm</th><th>Triggered</th><th>Recovered</th><th>Duration</th><th>Peak</th></tr>
+{{range .Episodes}}<tr><td>{{.Alarm}}</td><td>{{time .Start}}</td><td>{{if .Ongoing}}still active at the end{{else}}{{time .End}}{{end}}</td><td>{{duration .}}</td><td>{{peak .}} at {{time .PeakTime}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+
+<div class="section"><h2>Top sections</h2>{{template "counts" .TopSections}}</div>
+<div class="section"><h2>Hits per status class</h2>{{template "counts" .StatusClasses}}</div>
+<div class="section"><h2>Hits per status</h2>{{template "counts" .Statuses}}</div>
+<div class="section"><h2>Busiest seconds</h2>
+<table>{{range .BusiestSeconds}}<tr><td>{{time .Time}}</td><td class="hits">{{.Hits}}</td></tr>
+{{end}}</table></div>
+<div class="section"><h2>Busiest minutes</h2>
+<table>{{range .BusiestMinutes}}<tr><td>{{minute .Time}}</td><td class="hits">{{.Hits}}</td></tr>
+{{end}}</table></div>
+
+<h2>Intervals</h2>
+{{range .Intervals}}<details><summary>{{time .Start}} - {{time .End}}, hits {{.Hits}}</summary>{{template "counts" .TopSections}}</details>
+{{end}}{{end}}
+</body>
+</html>
+{{define "counts"}}<table>{{range .}}<tr><td>{{.Name}}</td><td class="hits">{{.Hits}}</td></tr>
+{{end}}</table>{{end}}
+`))
+
+func writeHTML(writer io.Writer, result Result) error {
+	return htmlTemplate.Execute(writer, result)
+}
+
+func newChart(result Result) chart {
+	span := result.LatestTime - result.FirstTime + 1
+	bucketSeconds := (span + maxChartPoints - 1) / maxChartPoints
+	buckets := make([]float64, (span+bucketSeconds-1)/bucketSeconds)
+	for _, v := range result.HitsPerSecond {
+		if v.Time < result.FirstTime || v.Time > result.LatestTime {
+			continue // late lines from before the start
+		}
+		buckets[(v.Time-result.FirstTime)/bucketSeconds] += float64(v.Hits) / float64(bucketSeconds)
+	}
+
+	c := chart{Width: chartWidth, Height: chartHeight, BucketSeconds: bucketSeconds}
+	for _, v := range buckets {
+		if v > c.MaxHits {
+			c.MaxHits = v
+		}
+	}
+
+	x := func(t uint64) float64 {
+		return float64(t-result.FirstTime) * chartWidth / float64(span)
+	}
+	y := func(hits float64) float64 {
+		if c.MaxHits == 0 {
+			return chartHeight
+		}
+		return chartHeight - hits*(chartHeight-20)/c.MaxHits // leaves room for the label
+	}
+
+	points := make([]string, 0, len(buckets)*2)
+	for i, v := range buckets {
+		start := result.FirstTime + uint64(i)*bucketSeconds
+		end := start + bucketSeconds
+		if end > result.LatestTime+1 {
+			end = result.LatestTime + 1
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f %.1f,%.1f", x(start), y(v), x(end), y(v)))
+	}
+	c.Points = strings.Join(points, " ")
+
+	for _, e := range result.Episodes {
+		if e.End < result.FirstTime || e.Start > result.LatestTime {
+			continue
+		}
+		c.Shades = append(c.Shades, shade{
+			X:     x(e.Start),
+			Width: x(e.End+1) - x(e.Start),
+			Class: strings.Replace(e.Alarm, " ", "-", -1),
+			Title: episodeText(e),
+		})
+	}
+
+	return c
+}
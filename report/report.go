@@ -0,0 +1,378 @@
+/*Package report aggregates a whole log file into a single report, e.g. for post-incident
+ * write-ups, instead of the interval by interval output
+ */
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/manage"
+	"github.com/hardboiled/apache-log-parser/parsing"
+)
+
+const (
+	topSections  = 10
+	intervalTop  = 5 // the same as the interval output
+	busiestTimes = 5
+	alarmPeriod  = 120 // seconds, the alarms are based on the hits over the last 2 mins
+	trafficAlarm = "high traffic"
+	latencyAlarm = "high latency"
+	minuteLayout = "2006-01-02 15:04 MST"
+)
+
+// Count is the number of hits for a section, status, etc.
+type Count struct {
+	Name string
+	Hits uint64
+}
+
+// TimeCount is the number of hits for a second or a minute, Time is the start of it
+type TimeCount struct {
+	Time uint64
+	Hits uint64
+}
+
+// Episode is an alarm from when it triggered until it recovered. Peak is the second with the most
+// hits over the last 2 mins for the traffic alarm, or the highest share of slow hits for the latency alarm.
+type Episode struct {
+	Alarm        string
+	Start        uint64
+	End          uint64
+	Ongoing      bool // the alarm was still active at the end of the input, End is the latest time read
+	PeakTime     uint64
+	PeakHits     uint64
+	PeakSlowHits uint64
+}
+
+// Interval is the stats of an interval as it was output by the pipeline
+type Interval struct {
+	Start       uint64
+	End         uint64
+	Hits        uint64
+	TopSections []Count
+}
+
+// Result is everything in the report
+type Result struct {
+	StopReason     string
+	LinesRead      uint64
+	LinesCounted   uint64
+	FirstTime      uint64
+	LatestTime     uint64
+	Hits           uint64
+	Bytes          uint64
+	TopSections    []Count
+	StatusClasses  []Count // e.g. 2xx
+	Statuses       []Count
+	BusiestSeconds []TimeCount
+	BusiestMinutes []TimeCount
+	Episodes       []Episode
+	HitsPerSecond  []TimeCount // every second with hits, in order
+	Intervals      []Interval
+}
+
+type secondCounts struct {
+	hits uint64
+	slow uint64
+}
+
+// Report is a `pipeline.LineSink` that aggregates every counted line along with the alarms,
+// and writes the report once the pipeline sends its summary
+type Report struct {
+	writer           io.Writer
+	format           func(io.Writer, Result) error
+	latencyThreshold uint64 // microseconds, 0 when the latency alarm is disabled
+	hits             uint64
+	bytes            uint64
+	sections         map[string]uint64
+	statuses         map[uint64]uint64
+	seconds          map[uint64]secondCounts
+	episodes         []Episode
+	open             map[string]int // index in episodes of the active episode for each alarm
+	intervals        []Interval
+}
+
+// New creates a Report that writes to writer, as text or as a self-contained html page depending
+// on config.ReportFormat
+func New(writer io.Writer, config manage.Config) *Report {
+	format := writeText
+	if config.ReportFormat == "html" {
+		format = writeHTML
+	}
+
+	return &Report{
+		writer:           writer,
+		format:           format,
+		latencyThreshold: uint64(config.LatencyThreshold) * 1000,
+		sections:         map[string]uint64{},
+		statuses:         map[uint64]uint64{},
+		seconds:          map[uint64]secondCounts{},
+		episodes:         []Episode{},
+		open:             map[string]int{},
+		intervals:        []Interval{},
+	}
+}
+
+// ProcessLine adds a counted line to the totals
+func (r *Report) ProcessLine(data *parsing.WebServerLogData) error {
+	r.hits++
+	r.bytes += data.Bytes
+	r.sections[data.RequestSection()]++
+	r.statuses[data.Status]++
+
+	counts := r.seconds[data.Date]
+	counts.hits++
+	if r.latencyThreshold > 0 && data.Latency > r.latencyThreshold {
+		counts.slow++
+	}
+	r.seconds[data.Date] = counts
+	return nil
+}
+
+// Process records intervals and alarm episodes, and writes the report on the summary
+func (r *Report) Process(data analytics.ProcessAndOutputData) error {
+	switch v := data.(type) {
+	case *analytics.SectionData:
+		r.addInterval(v)
+	case analytics.TotalHitsAlarm:
+		r.alarmChanged(trafficAlarm, v.Flag, v.CurrentTime)
+	case analytics.LatencyAlarm:
+		r.alarmChanged(latencyAlarm, v.Flag, v.CurrentTime)
+	case analytics.Summary:
+		return r.format(r.writer, r.result(v))
+	}
+
+	return nil
+}
+
+func (r *Report) addInterval(sd *analytics.SectionData) {
+	interval := Interval{Start: sd.Start(), End: sd.LatestTime}
+	sections := map[string]uint64{}
+	for _, v := range sd.Window {
+		interval.Hits += v.TotalHitsForTimeSlot
+		for k, hits := range v.Sections {
+			sections[k] += hits
+		}
+	}
+
+	interval.TopSections = topCounts(sections, intervalTop)
+	r.intervals = append(r.intervals, interval)
+}
+
+func (r *Report) alarmChanged(alarm string, active bool, currentTime uint64) {
+	if active {
+		r.open[alarm] = len(r.episodes)
+		r.episodes = append(r.episodes, Episode{Alarm: alarm, Start: currentTime})
+		return
+	}
+
+	if i, ok := r.open[alarm]; ok {
+		r.episodes[i].End = currentTime
+		delete(r.open, alarm)
+	}
+}
+
+func (r *Report) result(summary analytics.Summary) Result {
+	for _, i := range r.open {
+		r.episodes[i].End = summary.LatestTime
+		r.episodes[i].Ongoing = true
+	}
+	r.open = map[string]int{}
+
+	statuses := map[string]uint64{}
+	classes := map[string]uint64{}
+	for k, v := range r.statuses {
+		statuses[fmt.Sprint(k)] += v
+		classes[fmt.Sprintf("%dxx", k/100)] += v
+	}
+
+	seconds := map[uint64]uint64{}
+	minutes := map[uint64]uint64{}
+	for k, v := range r.seconds {
+		seconds[k] = v.hits
+		minutes[k-k%60] += v.hits
+	}
+
+	r.findPeaks()
+
+	return Result{
+		StopReason:     summary.StopReason,
+		LinesRead:      summary.LinesRead,
+		LinesCounted:   summary.LinesCounted,
+		FirstTime:      summary.FirstTime,
+		LatestTime:     summary.LatestTime,
+		Hits:           r.hits,
+		Bytes:          r.bytes,
+		TopSections:    topCounts(r.sections, topSections),
+		StatusClasses:  topCounts(classes, len(classes)),
+		Statuses:       topCounts(statuses, len(statuses)),
+		BusiestSeconds: busiest(seconds, busiestTimes),
+		BusiestMinutes: busiest(minutes, busiestTimes),
+		Episodes:       r.episodes,
+		HitsPerSecond:  inOrder(seconds),
+		Intervals:      r.intervals,
+	}
+}
+
+// findPeaks goes over the 2 min totals at every second with hits, since that's when they can go up
+func (r *Report) findPeaks() {
+	times := make([]uint64, 0, len(r.seconds))
+	for k := range r.seconds {
+		times = append(times, k)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	hits, slow := uint64(0), uint64(0)
+	oldest := 0
+	for _, t := range times {
+		hits += r.seconds[t].hits
+		slow += r.seconds[t].slow
+		for ; times[oldest]+alarmPeriod <= t; oldest++ {
+			hits -= r.seconds[times[oldest]].hits
+			slow -= r.seconds[times[oldest]].slow
+		}
+
+		for i := range r.episodes {
+			e := &r.episodes[i]
+			if t < e.Start || t > e.End {
+				continue
+			}
+
+			var higher bool
+			if e.Alarm == trafficAlarm {
+				higher = hits > e.PeakHits
+			} else {
+				// compare slow/hits > PeakSlowHits/PeakHits without dividing
+				higher = e.PeakHits == 0 || slow*e.PeakHits > e.PeakSlowHits*hits
+			}
+			if higher {
+				e.PeakTime, e.PeakHits, e.PeakSlowHits = t, hits, slow
+			}
+		}
+	}
+}
+
+// topCounts returns the n highest counts in descending order, ties are ordered by name
+func topCounts(counts map[string]uint64, n int) []Count {
+	result := make([]Count, 0, len(counts))
+	for k, v := range counts {
+		result = append(result, Count{Name: k, Hits: v})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Hits != result[j].Hits {
+			return result[i].Hits > result[j].Hits
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// busiest returns the n times with the most hits in descending order, ties are ordered by time
+func busiest(counts map[uint64]uint64, n int) []TimeCount {
+	result := inOrder(counts)
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Hits > result[j].Hits })
+
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// inOrder returns the counts ordered by time
+func inOrder(counts map[uint64]uint64) []TimeCount {
+	result := make([]TimeCount, 0, len(counts))
+	for k, v := range counts {
+		result = append(result, TimeCount{Time: k, Hits: v})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Time < result[j].Time })
+	return result
+}
+
+func writeText(writer io.Writer, result Result) error {
+	_, err := io.WriteString(writer, text(result))
+	return err
+}
+
+// text formats the report the same way as the rest of the command line output
+func text(result Result) string {
+	output := []string{fmt.Sprintf("Report - stopped after %s", result.StopReason)}
+	if result.LinesRead == 0 {
+		output = append(output, "\tno log lines were read")
+		return strings.Join(output, "\n") + "\n"
+	}
+
+	output = append(
+		output,
+		fmt.Sprintf("\tlines read %d, lines counted %d", result.LinesRead, result.LinesCounted),
+		fmt.Sprintf("\ttime range %s - %s", formatTime(result.FirstTime), formatTime(result.LatestTime)),
+		fmt.Sprintf("\ttotal hits %d, total bytes %d", result.Hits, result.Bytes),
+	)
+
+	output = appendCounts(output, "top sections", result.TopSections)
+	output = appendCounts(output, "hits per status class", result.StatusClasses)
+	output = appendCounts(output, "hits per status", result.Statuses)
+
+	output = append(output, "\tbusiest seconds")
+	for _, v := range result.BusiestSeconds {
+		output = append(output, fmt.Sprintf("\t %s -> hits: %d", formatTime(v.Time), v.Hits))
+	}
+	output = append(output, "\tbusiest minutes")
+	for _, v := range result.BusiestMinutes {
+		output = append(output, fmt.Sprintf("\t %s -> hits: %d", formatMinute(v.Time), v.Hits))
+	}
+
+	output = append(output, "\talarm episodes")
+	if len(result.Episodes) == 0 {
+		output = append(output, "\t none")
+	}
+	for _, e := range result.Episodes {
+		output = append(output, "\t "+episodeText(e))
+	}
+
+	return strings.Join(output, "\n") + "\n"
+}
+
+func appendCounts(output []string, title string, counts []Count) []string {
+	output = append(output, "\t"+title)
+	for _, v := range counts {
+		output = append(output, fmt.Sprintf("\t %s -> hits: %d", v.Name, v.Hits))
+	}
+	return output
+}
+
+func episodeText(e Episode) string {
+	text := fmt.Sprintf("%s alert %s - %s (%v)", e.Alarm, formatTime(e.Start), formatTime(e.End), episodeDuration(e))
+	if e.Ongoing {
+		text += ", still active at the end"
+	}
+
+	return text + fmt.Sprintf(", %s at %s", peakText(e), formatTime(e.PeakTime))
+}
+
+func episodeDuration(e Episode) time.Duration {
+	return time.Duration(e.End-e.Start) * time.Second
+}
+
+func peakText(e Episode) string {
+	if e.Alarm == trafficAlarm {
+		return fmt.Sprintf("peak hits = %d over 2 mins", e.PeakHits)
+	}
+	return fmt.Sprintf("peak slow requests = %d of %d over 2 mins", e.PeakSlowHits, e.PeakHits)
+}
+
+func formatTime(t uint64) string {
+	return time.Unix(int64(t), 0).String()
+}
+
+func formatMinute(t uint64) string {
+	return time.Unix(int64(t), 0).Format(minuteLayout)
+}
@@ -0,0 +1,125 @@
+package report_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/manage"
+	"github.com/hardboiled/apache-log-parser/parsing"
+	"github.com/hardboiled/apache-log-parser/report"
+	"github.com/hardboiled/apache-log-parser/webstats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestReport(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Report Suite")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+var _ = Describe("Report", func() {
+	var buf *bytes.Buffer
+	var r *report.Report
+	startTime := uint64(1549573860)
+
+	line := func(date uint64, request string, status uint64, latency uint64) {
+		data := parsing.WebServerLogData{RemoteHost: "10.0.0.1", Date: date, Request: request, Status: status, Bytes: 100, Latency: latency}
+		Expect(r.ProcessLine(&data)).To(BeNil())
+	}
+
+	summary := func(latestTime uint64) analytics.Summary {
+		return analytics.Summary{StopReason: "end of input", LinesRead: 6, LinesCounted: 6, FirstTime: startTime, LatestTime: latestTime}
+	}
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		r = report.New(buf, manage.Config{LatencyThreshold: 500})
+	})
+
+	It("only writes the report on the summary", func() {
+		line(startTime, "GET /api/user HTTP/1.0", 200, 0)
+		Expect(r.Process(&analytics.SectionData{LatestTime: startTime})).To(BeNil())
+		Expect(buf.String()).To(BeEmpty())
+
+		Expect(r.Process(summary(startTime))).To(BeNil())
+		Expect(buf.String()).To(HavePrefix("Report - stopped after end of input\n"))
+	})
+
+	It("totals sections, statuses, bytes and the busiest times", func() {
+		line(startTime, "GET /api/user HTTP/1.0", 200, 0)
+		line(startTime, "GET /api/user HTTP/1.0", 200, 0)
+		line(startTime+1, "GET /report HTTP/1.0", 404, 0)
+		line(startTime+60, "GET /api/user HTTP/1.0", 500, 0)
+		line(startTime+61, "GET /api/user HTTP/1.0", 503, 0)
+		line(startTime+62, "GET /api/user HTTP/1.0", 201, 0)
+		Expect(r.Process(summary(startTime + 62))).To(BeNil())
+
+		output := buf.String()
+		Expect(output).To(ContainSubstring("total hits 6, total bytes 600\n"))
+		Expect(output).To(ContainSubstring("top sections\n\t /api -> hits: 5\n\t /report -> hits: 1\n"))
+		Expect(output).To(ContainSubstring("hits per status class\n\t 2xx -> hits: 3\n\t 5xx -> hits: 2\n\t 4xx -> hits: 1\n"))
+		Expect(output).To(ContainSubstring("hits per status\n\t 200 -> hits: 2\n\t 201 -> hits: 1\n"))
+		Expect(output).To(MatchRegexp(`busiest seconds\n\t \S+ \S+ \S+ \S+ -> hits: 2\n`))
+		Expect(output).To(MatchRegexp(`busiest minutes\n\t \S+ \S+ \S+ -> hits: 3\n\t \S+ \S+ \S+ -> hits: 3\n`))
+		Expect(output).To(ContainSubstring("alarm episodes\n\t none\n"))
+	})
+
+	It("reports alarm episodes with their peak", func() {
+		for i := uint64(0); i < 10; i++ {
+			line(startTime+i, "GET /api/user HTTP/1.0", 200, 0)
+		}
+		line(startTime+5, "GET /api/user HTTP/1.0", 200, 0)
+		Expect(r.Process(analytics.TotalHitsAlarm{Flag: true, Hits: 3, CurrentTime: startTime + 2})).To(BeNil())
+		Expect(r.Process(analytics.TotalHitsAlarm{Flag: false, Hits: 4, CurrentTime: startTime + 5})).To(BeNil())
+
+		line(startTime+200, "GET /api/user HTTP/1.0", 200, 600000) // microseconds
+		line(startTime+201, "GET /api/user HTTP/1.0", 200, 0)
+		Expect(r.Process(analytics.LatencyAlarm{Flag: true, SlowHits: 1, Hits: 1, CurrentTime: startTime + 200})).To(BeNil())
+		Expect(r.Process(summary(startTime + 201))).To(BeNil())
+
+		output := buf.String()
+		Expect(output).To(MatchRegexp(`high traffic alert .* \(3s\), peak hits = 7 over 2 mins at`))
+		Expect(output).To(MatchRegexp(`high latency alert .* \(1s\), still active at the end, peak slow requests = 1 of 1 over 2 mins at`))
+	})
+
+	It("says when no lines were read", func() {
+		Expect(r.Process(analytics.Summary{StopReason: "end of input"})).To(BeNil())
+		Expect(buf.String()).To(Equal("Report - stopped after end of input\n\tno log lines were read\n"))
+	})
+
+	It("renders a self-contained html page", func() {
+		r = report.New(buf, manage.Config{ReportFormat: "html"})
+		for i := uint64(0); i < 20; i++ {
+			line(startTime+i, "GET /api/user HTTP/1.0", 200, 0)
+		}
+		line(startTime+3, "GET /<script> HTTP/1.0", 200, 0)
+		Expect(r.Process(&analytics.SectionData{
+			LatestTime: startTime + 9,
+			Window:     []webstats.WindowEntry{{Sections: map[string]uint64{"/api": 4}, TotalHitsForTimeSlot: 4}, {TotalHitsForTimeSlot: 0}},
+		})).To(BeNil())
+		Expect(r.Process(analytics.TotalHitsAlarm{Flag: true, Hits: 3, CurrentTime: startTime + 2})).To(BeNil())
+		Expect(r.Process(summary(startTime + 19))).To(BeNil())
+
+		output := buf.String()
+		Expect(output).To(HavePrefix("<!DOCTYPE html>"))
+		Expect(output).ToNot(MatchRegexp(`(src|href)=`)) // no external assets
+		Expect(output).To(ContainSubstring("<svg"))
+		Expect(output).To(MatchRegexp(`<rect class="high-traffic" x="96.0" y="0" width="864.0"`))
+		Expect(output).To(ContainSubstring("still active at the end"))
+		Expect(output).To(MatchRegexp(`<details><summary>[^<]+, hits 4</summary><table><tr><td>/api</td><td class="hits">4</td></tr>`))
+		Expect(output).To(ContainSubstring("/&lt;script&gt;"))
+	})
+
+	It("returns write errors", func() {
+		r = report.New(failingWriter{}, manage.Config{})
+		Expect(r.Process(summary(startTime))).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,90 @@
+/*Package state saves the progress of the analyzer to a local file, so that
+ * a restarted agent resumes where it left off instead of starting over
+ */
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/checkpoint"
+	"github.com/hardboiled/apache-log-parser/webstats"
+)
+
+// Version is bumped whenever the encoding of State changes. Version 3 added the traffic per section and
+// the rollups to the snapshot, and older files are rejected rather than restored without them.
+const Version = 3
+
+// MinVersion is the oldest version that can still be loaded
+const MinVersion = 3
+
+// State is everything needed to resume processing
+type State struct {
+	Version      int
+	WebStats     webstats.Snapshot
+	Schedule     analytics.ScheduleState
+	FirstTime    uint64
+	LinesRead    uint64
+	LinesCounted uint64
+	Checkpoint   checkpoint.Position // end of the last line processed, saved with the stats so no line is counted twice
+}
+
+// Save writes state to path. It's written to a temp file that replaces path,
+// so a crash while saving leaves the previous state behind rather than a partial one.
+func Save(path string, state State) error {
+	state.Version = Version
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads the state saved at path, it returns false if nothing has been saved yet
+func Load(path string) (State, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return State{}, false, nil
+	}
+	if err != nil {
+		return State{}, false, err
+	}
+
+	state := State{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{}, false, fmt.Errorf("state file %s is invalid: %v", path, err)
+	}
+
+	if state.Version < MinVersion {
+		return State{}, false, fmt.Errorf("state file %s has version %d, which is too old to restore the stats from, remove it to start over", path, state.Version)
+	}
+
+	if state.Version > Version {
+		return State{}, false, fmt.Errorf("state file %s has version %d, expected %d or less", path, state.Version, Version)
+	}
+
+	return state, true, nil
+}
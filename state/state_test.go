@@ -0,0 +1,88 @@
+package state_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/analytics"
+	"github.com/hardboiled/apache-log-parser/state"
+	"github.com/hardboiled/apache-log-parser/webstats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestState(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "State Suite")
+}
+
+var _ = Describe("State", func() {
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "state")
+		Expect(err).To(BeNil())
+		path = filepath.Join(dir, "state.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("loads nothing before it's saved", func() {
+		_, ok, err := state.Load(path)
+		Expect(err).To(BeNil())
+		Expect(ok).To(Equal(false))
+	})
+
+	It("loads what was saved", func() {
+		saved := state.State{
+			WebStats: webstats.Snapshot{
+				Window: []webstats.WindowEntry{{
+					Sections:             map[string]uint64{"/api": 3},
+					SectionTraffic:       map[string]webstats.SectionTraffic{"/api": {Bytes: 10, StatusClasses: [6]uint64{0, 0, 3}}},
+					TotalHitsForTimeSlot: 3,
+				}},
+				LatestTime:           1549574340,
+				TotalHitsForLast2Min: 3,
+				Rollups: []webstats.RollupSnapshot{{
+					Resolution: time.Minute,
+					Slots:      []webstats.WindowEntry{{Sections: map[string]uint64{"/api": 3}, TotalHitsForTimeSlot: 3}},
+					Periods:    []uint64{1549574340 / 60},
+				}},
+			},
+			Schedule:  analytics.ScheduleState{LastTimeProcessed: 1549574330},
+			LinesRead: 3,
+		}
+		Expect(state.Save(path, saved)).To(BeNil())
+
+		loaded, ok, err := state.Load(path)
+		Expect(err).To(BeNil())
+		Expect(ok).To(Equal(true))
+		saved.Version = state.Version
+		Expect(loaded).To(Equal(saved))
+
+		files, _ := ioutil.ReadDir(dir)
+		Expect(len(files)).To(Equal(1)) // no temp files left behind
+	})
+
+	It("rejects files saved before the snapshot had section traffic and rollups", func() {
+		for _, version := range []string{"1", "2"} {
+			Expect(ioutil.WriteFile(path, []byte(`{"Version":`+version+`,"LinesRead":3}`), 0644)).To(BeNil())
+			_, ok, err := state.Load(path)
+			Expect(err).To(MatchError(ContainSubstring("too old to restore")))
+			Expect(ok).To(Equal(false))
+		}
+	})
+
+	It("rejects other versions", func() {
+		Expect(ioutil.WriteFile(path, []byte(`{"Version":999}`), 0644)).To(BeNil())
+		_, _, err := state.Load(path)
+		Expect(err).ToNot(BeNil())
+	})
+})
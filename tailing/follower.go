@@ -0,0 +1,281 @@
+/*Package tailing reads log files that are still being written to, like `tail -f`
+ */
+package tailing
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/checkpoint"
+)
+
+// Follower reads a file one complete line at a time and waits for new lines instead of returning io.EOF.
+// The header line is always read first so the csv parser still sees it, and when the file is rotated
+// or truncated, the follower moves on to the new file, skipping its header. Files without a header
+// are opened with `OpenHeaderlessFollower`.
+type Follower struct {
+	path         string
+	mu           sync.Mutex // guards file and reader, which are swapped on rotation and closed by Close
+	file         *os.File
+	reader       *bufio.Reader
+	position     checkpoint.Position // end of the last complete line read from file
+	partial      []byte              // start of a line that's still being written
+	header       []byte              // returned by the first ReadLine
+	skipHeader   bool                // the next line is the header of a file opened after a rotation
+	hasHeader    bool                // false for formats without a header, whose first line is a log line
+	pending      []byte              // rest of a line that didn't fit in Read's buffer
+	pollInterval time.Duration
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+// OpenFollower opens the file at path and resumes after the line that ends at resumeAt. If the file was
+// rotated since, the rest of the rotated file is read first, as long as it's still in the same directory.
+// A zero position starts after the last complete line.
+func OpenFollower(path string, pollInterval time.Duration, resumeAt checkpoint.Position) (*Follower, error) {
+	return openFollower(path, pollInterval, resumeAt, true)
+}
+
+// OpenHeaderlessFollower works like `OpenFollower` for files without a header, e.g. in the common log format
+func OpenHeaderlessFollower(path string, pollInterval time.Duration, resumeAt checkpoint.Position) (*Follower, error) {
+	return openFollower(path, pollInterval, resumeAt, false)
+}
+
+func openFollower(path string, pollInterval time.Duration, resumeAt checkpoint.Position, hasHeader bool) (*Follower, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	device, inode := fileIdentity(info)
+
+	resuming := resumeAt != checkpoint.Position{}
+	if resuming && (resumeAt.Device != device || resumeAt.Inode != inode) {
+		if rotated, rotatedInfo := openRotated(path, resumeAt); rotated != nil {
+			file.Close()
+			file, info = rotated, rotatedInfo
+			device, inode = resumeAt.Device, resumeAt.Inode
+		}
+	}
+
+	var header []byte
+	if hasHeader {
+		header, err = bufio.NewReader(file).ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	offset := int64(0) // without a complete header yet, the whole file is read as it's written
+	if err == nil {
+		switch {
+		case !resuming:
+			offset = info.Size()
+		case resumeAt.Device == device && resumeAt.Inode == inode && resumeAt.Offset <= info.Size():
+			offset = resumeAt.Offset
+		default:
+			// the checkpoint is for a file that's gone, or this one was truncated, so every line is new
+			offset = int64(len(header))
+		}
+		if offset < int64(len(header)) {
+			offset = int64(len(header))
+		}
+	} else {
+		header = nil
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Follower{
+		path:         path,
+		file:         file,
+		reader:       bufio.NewReader(file),
+		position:     checkpoint.Position{Device: device, Inode: inode, Offset: offset},
+		header:       header,
+		hasHeader:    hasHeader,
+		pollInterval: pollInterval,
+		closed:       make(chan struct{}),
+	}, nil
+}
+
+// openRotated looks for the file of resumeAt next to path, e.g. access.log.1 for access.log
+func openRotated(path string, resumeAt checkpoint.Position) (*os.File, os.FileInfo) {
+	infos, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, nil
+	}
+
+	base := filepath.Base(path)
+	for _, info := range infos {
+		if info.Name() == base || !strings.HasPrefix(info.Name(), base) {
+			continue
+		}
+
+		if device, inode := fileIdentity(info); device == resumeAt.Device && inode == resumeAt.Inode {
+			file, err := os.Open(filepath.Join(filepath.Dir(path), info.Name()))
+			if err != nil {
+				return nil, nil
+			}
+			return file, info
+		}
+	}
+
+	return nil, nil
+}
+
+// ReadLine returns the next complete line and the position where it ends, waiting for one to be
+// written if needed. It returns io.EOF once the follower is closed.
+func (f *Follower) ReadLine() ([]byte, checkpoint.Position, error) {
+	if f.header != nil {
+		header := f.header
+		f.header = nil
+		return header, checkpoint.Position{}, nil
+	}
+
+	for {
+		line, position, err := f.readLine()
+		if err != nil || line != nil {
+			return line, position, err
+		}
+
+		select {
+		case <-f.closed:
+			return nil, checkpoint.Position{}, io.EOF
+		case <-time.After(f.pollInterval):
+		}
+	}
+}
+
+// readLine reads a complete line if there is one, it returns a nil line when it needs to wait for more
+func (f *Follower) readLine() ([]byte, checkpoint.Position, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	select {
+	case <-f.closed:
+		return nil, checkpoint.Position{}, io.EOF
+	default:
+	}
+
+	for {
+		chunk, err := f.reader.ReadBytes('\n')
+		f.partial = append(f.partial, chunk...)
+		if err == nil {
+			line := f.partial
+			f.partial = nil
+			f.position.Offset += int64(len(line))
+			if f.skipHeader {
+				f.skipHeader = false
+				continue
+			}
+			return line, f.position, nil
+		}
+
+		if err != io.EOF {
+			return nil, checkpoint.Position{}, err
+		}
+
+		reopened, err := f.reopenIfRotated()
+		if err != nil || !reopened {
+			return nil, checkpoint.Position{}, err
+		}
+	}
+}
+
+// reopenIfRotated switches to the file at path if it's no longer the one being read, either because it was
+// renamed or truncated. It's only called at the end of the file being read.
+func (f *Follower) reopenIfRotated() (bool, error) {
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		return false, nil // rotated, but the new file hasn't been created yet
+	}
+	if err != nil {
+		return false, err
+	}
+
+	read := f.position.Offset + int64(len(f.partial))
+	device, inode := fileIdentity(info)
+	if device == f.position.Device && inode == f.position.Inode {
+		if info.Size() >= read {
+			return false, nil
+		}
+
+		// truncated, e.g. by logrotate's copytruncate, so start over after the header
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		f.reset(f.file, device, inode)
+		return true, nil
+	}
+
+	// the writer can still add lines to the rotated file for a moment, so wait until they're read
+	if current, err := f.file.Stat(); err == nil && current.Size() > read {
+		return false, nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return false, err
+	}
+	// files can be swapped again between the stat and the open, so get the identity of the one opened
+	if info, err = file.Stat(); err != nil {
+		file.Close()
+		return false, err
+	}
+	f.file.Close()
+	device, inode = fileIdentity(info)
+	f.reset(file, device, inode)
+	return true, nil
+}
+
+// reset reads file from the start, apache always ends lines with a newline, so a partial line is only
+// left behind when the writer was cut off
+func (f *Follower) reset(file *os.File, device, inode uint64) {
+	f.file = file
+	f.reader = bufio.NewReader(file)
+	f.position = checkpoint.Position{Device: device, Inode: inode}
+	f.partial = nil
+	f.skipHeader = f.hasHeader
+}
+
+// Read implements io.Reader on top of ReadLine, so the follower can be used as a plain stream as well
+func (f *Follower) Read(p []byte) (int, error) {
+	if len(f.pending) == 0 {
+		line, _, err := f.ReadLine()
+		if err != nil {
+			return 0, err
+		}
+		f.pending = line
+	}
+
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// Close stops a pending Read and closes the file
+func (f *Follower) Close() error {
+	err := os.ErrClosed
+	f.closeOnce.Do(func() {
+		close(f.closed)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		err = f.file.Close()
+	})
+	return err
+}
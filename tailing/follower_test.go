@@ -0,0 +1,180 @@
+package tailing_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hardboiled/apache-log-parser/checkpoint"
+	"github.com/hardboiled/apache-log-parser/tailing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestTailing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tailing Suite")
+}
+
+var _ = Describe("Follower", func() {
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tailing")
+		Expect(err).To(BeNil())
+		path = filepath.Join(dir, "access.log")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	appendToFile := func(data string) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		Expect(err).To(BeNil())
+		defer file.Close()
+		_, err = file.WriteString(data)
+		Expect(err).To(BeNil())
+	}
+
+	readAll := func(f *tailing.Follower) chan string {
+		out := make(chan string, 1)
+		go func() {
+			data, _ := ioutil.ReadAll(f)
+			out <- string(data)
+		}()
+		return out
+	}
+
+	It("skips existing lines but keeps the header", func() {
+		appendToFile("header\nold line\n")
+		f, err := tailing.OpenFollower(path, time.Millisecond, checkpoint.Position{})
+		Expect(err).To(BeNil())
+
+		out := readAll(f)
+		appendToFile("new line\n")
+		time.Sleep(20 * time.Millisecond)
+		f.Close()
+
+		Eventually(out).Should(Receive(Equal("header\nnew line\n")))
+	})
+
+	It("reads the whole file when the header isn't written yet", func() {
+		appendToFile("head")
+		f, err := tailing.OpenFollower(path, time.Millisecond, checkpoint.Position{})
+		Expect(err).To(BeNil())
+
+		out := readAll(f)
+		appendToFile("er\nline\n")
+		time.Sleep(20 * time.Millisecond)
+		f.Close()
+
+		Eventually(out).Should(Receive(Equal("header\nline\n")))
+	})
+
+	It("returns EOF once closed", func() {
+		appendToFile("header\n")
+		f, err := tailing.OpenFollower(path, time.Millisecond, checkpoint.Position{})
+		Expect(err).To(BeNil())
+		Expect(f.Close()).To(BeNil())
+
+		buf := make([]byte, 16)
+		n, err := f.Read(buf)
+		Expect(n).To(Equal(7))
+		_, err = f.Read(buf)
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	readLines := func(f *tailing.Follower, n int) ([]string, checkpoint.Position) {
+		lines := []string{}
+		var position checkpoint.Position
+		for len(lines) < n {
+			line, pos, err := f.ReadLine()
+			Expect(err).To(BeNil())
+			lines = append(lines, string(line))
+			position = pos
+		}
+		return lines, position
+	}
+
+	It("resumes after the line at the checkpoint", func() {
+		appendToFile("header\nline 1\n")
+		f, err := tailing.OpenFollower(path, time.Millisecond, checkpoint.Position{})
+		Expect(err).To(BeNil())
+		appendToFile("line 2\nline 3\n")
+		_, checkpoint := readLines(f, 2) // the header and line 2
+		f.Close()
+
+		f, err = tailing.OpenFollower(path, time.Millisecond, checkpoint)
+		Expect(err).To(BeNil())
+		defer f.Close()
+		lines, _ := readLines(f, 2)
+		Expect(lines).To(Equal([]string{"header\n", "line 3\n"}))
+	})
+
+	It("finishes the rotated file before reading the new one", func() {
+		appendToFile("header\n")
+		f, err := tailing.OpenFollower(path, time.Millisecond, checkpoint.Position{})
+		Expect(err).To(BeNil())
+		defer f.Close()
+		appendToFile("line 1\nline 2\n")
+		readLines(f, 2)
+
+		Expect(os.Rename(path, path+".1")).To(BeNil())
+		appendToFile("header\nline 3\n")
+		lines, _ := readLines(f, 2)
+		Expect(lines).To(Equal([]string{"line 2\n", "line 3\n"}))
+	})
+
+	It("resumes across a rotation that happened while it was stopped", func() {
+		appendToFile("header\n")
+		f, err := tailing.OpenFollower(path, time.Millisecond, checkpoint.Position{})
+		Expect(err).To(BeNil())
+		appendToFile("line 1\nline 2\n")
+		_, checkpoint := readLines(f, 2)
+		f.Close()
+
+		Expect(os.Rename(path, path+".1")).To(BeNil())
+		appendToFile("header\nline 3\n")
+
+		f, err = tailing.OpenFollower(path, time.Millisecond, checkpoint)
+		Expect(err).To(BeNil())
+		defer f.Close()
+		lines, _ := readLines(f, 3)
+		Expect(lines).To(Equal([]string{"header\n", "line 2\n", "line 3\n"}))
+	})
+
+	It("starts over when the file is truncated", func() {
+		appendToFile("header\n")
+		f, err := tailing.OpenFollower(path, time.Millisecond, checkpoint.Position{})
+		Expect(err).To(BeNil())
+		defer f.Close()
+		appendToFile("a much longer line 1\n")
+		readLines(f, 2)
+
+		Expect(os.Truncate(path, 0)).To(BeNil())
+		appendToFile("header\nline 2\n")
+		lines, _ := readLines(f, 1)
+		Expect(lines).To(Equal([]string{"line 2\n"}))
+	})
+
+	It("reads every line of a file without a header, including after a rotation", func() {
+		appendToFile("old line\n")
+		f, err := tailing.OpenHeaderlessFollower(path, time.Millisecond, checkpoint.Position{})
+		Expect(err).To(BeNil())
+		defer f.Close()
+		appendToFile("line 1\n")
+		lines, _ := readLines(f, 1)
+		Expect(lines).To(Equal([]string{"line 1\n"}))
+
+		Expect(os.Rename(path, path+".1")).To(BeNil())
+		appendToFile("line 2\n")
+		lines, _ = readLines(f, 1)
+		Expect(lines).To(Equal([]string{"line 2\n"}))
+	})
+})
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package tailing
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode of a file, which stay the same when it's renamed
+func fileIdentity(info os.FileInfo) (uint64, uint64) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), uint64(stat.Ino)
+	}
+	return 0, 0
+}
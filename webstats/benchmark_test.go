@@ -0,0 +1,60 @@
+package webstats_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hardboiled/apache-log-parser/webstats"
+)
+
+var benchmarkSections = []string{"/api", "/report", "/static", "/login", "/search"}
+
+// benchmarkStartTime is a minute boundary, so 100 entries per second fill a few rollup periods
+const benchmarkStartTime = 1549573860
+
+// benchmarkWebStats returns WebStats with the default rollups, like the agent uses
+func benchmarkWebStats(b *testing.B) *webstats.WebStats {
+	ws, err := webstats.InitWebStats(600, 10, benchmarkStartTime)
+	if err != nil {
+		b.Fatal(err)
+	}
+	rollups, err := webstats.ParseRollups(webstats.DefaultRollups)
+	if err == nil {
+		err = ws.SetRollups(rollups)
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+	return ws
+}
+
+func BenchmarkAddEntry(b *testing.B) {
+	ws := benchmarkWebStats(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ws.AddEntry(benchmarkSections[i%len(benchmarkSections)], benchmarkStartTime+uint64(i/100))
+	}
+}
+
+func BenchmarkRecord(b *testing.B) {
+	ws := benchmarkWebStats(b)
+	hosts := make([]string, 256)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.0.0.%d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ws.Record(webstats.Entry{
+			Section:    benchmarkSections[i%len(benchmarkSections)],
+			RemoteHost: hosts[i%len(hosts)],
+			Time:       benchmarkStartTime + uint64(i/100),
+			Latency:    uint64(i % 3000),
+			Status:     200,
+			Bytes:      1234,
+		})
+	}
+}
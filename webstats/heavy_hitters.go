@@ -0,0 +1,80 @@
+package webstats
+
+// MaxClientsPerTimeSlot bounds how many remote hosts are tracked for each second in the window
+const MaxClientsPerTimeSlot = 64
+
+// boundedCounts counts hits per key without growing past a capacity of keys.
+// This is the Space-Saving algorithm: once full, the key with the fewest hits is evicted and the
+// new key inherits its count. Counts can be over estimated, but a heavy hitter is never dropped.
+// The hits each key inherited are kept as well, so its count minus them is hits it certainly had.
+type boundedCounts struct {
+	counts    map[string]uint64
+	inherited map[string]uint64 // only keys that replaced another one
+	minKeys   []string          // keys that had minHits at the last scan, they're evicted before scanning again
+	minHits   uint64
+}
+
+func (bc *boundedCounts) increment(key string, capacity int) {
+	if bc.counts == nil {
+		bc.counts = map[string]uint64{}
+	}
+
+	if hits, ok := bc.counts[key]; ok {
+		bc.counts[key] = hits + 1
+		return
+	}
+
+	key = cloneString(key) // it's kept, so it shouldn't keep the line it was parsed from
+	if len(bc.counts) < capacity {
+		bc.counts[key] = 1
+		return
+	}
+
+	evicted := bc.minKey()
+	delete(bc.counts, evicted)
+	delete(bc.inherited, evicted)
+	if bc.inherited == nil {
+		bc.inherited = map[string]uint64{}
+	}
+	bc.counts[key] = bc.minHits + 1
+	bc.inherited[key] = bc.minHits
+}
+
+// minKey returns a key with the fewest hits. Counts only go up and new keys start above minHits, so a
+// key that still has minHits has the fewest, and the map is only scanned once those keys are used up.
+// With many one off clients, that makes eviction O(1) instead of a scan for every new client.
+func (bc *boundedCounts) minKey() string {
+	for len(bc.minKeys) > 0 {
+		key := bc.minKeys[len(bc.minKeys)-1]
+		bc.minKeys = bc.minKeys[:len(bc.minKeys)-1]
+		if hits, ok := bc.counts[key]; ok && hits == bc.minHits {
+			return key
+		}
+	}
+
+	bc.minHits = ^uint64(0)
+	for k, v := range bc.counts {
+		if v < bc.minHits {
+			bc.minHits = v
+			bc.minKeys = bc.minKeys[:0]
+		}
+		if v == bc.minHits {
+			bc.minKeys = append(bc.minKeys, k)
+		}
+	}
+
+	key := bc.minKeys[len(bc.minKeys)-1]
+	bc.minKeys = bc.minKeys[:len(bc.minKeys)-1]
+	return key
+}
+
+// reset empties the counts, keeping the map
+func (bc *boundedCounts) reset() {
+	for k := range bc.counts {
+		delete(bc.counts, k)
+	}
+	for k := range bc.inherited {
+		delete(bc.inherited, k)
+	}
+	bc.minKeys = bc.minKeys[:0]
+}
@@ -0,0 +1,88 @@
+package webstats
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ClientHits is the number of hits recorded for a remote host. Once more than MaxClientsPerTimeSlot
+// hosts hit in the same second, a new host takes over the count of the one it replaces, so only Hits
+// certainly came from the host and up to Uncertain more may have.
+type ClientHits struct {
+	RemoteHost string
+	Hits       uint64
+	Uncertain  uint64
+}
+
+// EnableRateLimit turns on per client rate limit checks. A client violates the limit when it
+// certainly has more than limit hits over the last `seconds` seconds, see `ClientHits`.
+func (ws *WebStats) EnableRateLimit(limit, seconds uint64) error {
+	if limit == 0 {
+		return fmt.Errorf("%d is an invalid rate limit", limit)
+	}
+
+	if seconds == 0 || seconds > uint64(ws.WindowSize()) {
+		return fmt.Errorf("rate limit period of %d seconds must be between 1 and the window size %d", seconds, ws.WindowSize())
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.rateLimit = limit
+	ws.rateLimitSeconds = seconds
+	return nil
+}
+
+// DisableRateLimit turns off per client rate limit checks
+func (ws *WebStats) DisableRateLimit() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.rateLimit = 0
+}
+
+// RateLimit returns the configured limit and period in seconds, a limit of 0 means it's disabled
+func (ws *WebStats) RateLimit() (uint64, uint64) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.rateLimit, ws.rateLimitSeconds
+}
+
+// RateLimitOffenders returns the clients over the rate limit for the period ending at curTime,
+// ordered by hits descending
+func (ws *WebStats) RateLimitOffenders(curTime uint64) []ClientHits {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	if ws.rateLimit == 0 {
+		return nil
+	}
+
+	hitsPerClient := map[string]uint64{}
+	inheritedPerClient := map[string]uint64{}
+	for _, timeSlot := range ws.windowForRange(curTime, ws.rateLimitSeconds-1) {
+		for k, v := range timeSlot.clients.counts {
+			hitsPerClient[k] += v
+		}
+		for k, v := range timeSlot.clients.inherited {
+			inheritedPerClient[k] += v
+		}
+	}
+
+	// inherited hits don't count towards the limit, so a busy second can't get a host reported
+	// for other hosts' hits
+	offenders := []ClientHits{}
+	for k, v := range hitsPerClient {
+		inherited := inheritedPerClient[k]
+		if v-inherited > ws.rateLimit {
+			offenders = append(offenders, ClientHits{RemoteHost: k, Hits: v - inherited, Uncertain: inherited})
+		}
+	}
+
+	sort.Slice(offenders, func(i, j int) bool {
+		if offenders[i].Hits == offenders[j].Hits {
+			return offenders[i].RemoteHost < offenders[j].RemoteHost
+		}
+		return offenders[i].Hits > offenders[j].Hits
+	})
+
+	return offenders
+}
@@ -0,0 +1,262 @@
+package webstats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rollup is a coarser resolution that's kept alongside the per second window, so long ranges can be
+// read without keeping a slot for every second
+type Rollup struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// DefaultRollups keep minutes for a day and hours for 30 days, in the form `ParseRollups` reads.
+// That's 1440 + 720 slots, compared to 2.6 million for 30 days of seconds.
+const DefaultRollups = "1m:24h,1h:720h"
+
+// MaxRollupSlots is the most slots a single rollup can keep, its retention divided by its resolution
+const MaxRollupSlots = 1 << 16
+
+// ParseRollups parses rollups in the form "resolution:retention,resolution:retention" with go durations,
+// e.g. `DefaultRollups`. "" is no rollups. They're returned from the finest resolution to the coarsest.
+func ParseRollups(rollups string) ([]Rollup, error) {
+	result := []Rollup{}
+	for _, v := range strings.Split(rollups, ",") {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("rollup %q must be in the form resolution:retention, e.g. 1m:24h", v)
+		}
+
+		resolution, err := time.ParseDuration(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("rollup %q has an invalid resolution: %v", v, err)
+		}
+		retention, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("rollup %q has an invalid retention: %v", v, err)
+		}
+
+		result = append(result, Rollup{Resolution: resolution, Retention: retention})
+	}
+
+	if err := validateRollups(result); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Resolution < result[j].Resolution })
+	return result, nil
+}
+
+func validateRollups(rollups []Rollup) error {
+	seen := map[time.Duration]bool{}
+	for _, v := range rollups {
+		switch {
+		case v.Resolution <= time.Second || v.Resolution%time.Second != 0:
+			return fmt.Errorf("rollup resolution of %v must be whole seconds and > 1s, seconds are kept by the window", v.Resolution)
+		case v.Retention <= v.Resolution || v.Retention%v.Resolution != 0:
+			return fmt.Errorf("rollup retention of %v must be a multiple of its resolution of %v", v.Retention, v.Resolution)
+		case v.Retention/v.Resolution > MaxRollupSlots:
+			return fmt.Errorf("rollup of %v for %v would keep more than %d slots", v.Resolution, v.Retention, MaxRollupSlots)
+		case seen[v.Resolution]:
+			return fmt.Errorf("there's more than one rollup with a resolution of %v", v.Resolution)
+		}
+		seen[v.Resolution] = true
+	}
+
+	return nil
+}
+
+// RollupForInterval returns the finest of rollups that an interval of that many seconds can be read from.
+// Its resolution has to divide the interval, and like the window, it has to hold at least two intervals.
+func RollupForInterval(rollups []Rollup, interval uint64) (Rollup, bool) {
+	length := time.Duration(interval) * time.Second
+	var result Rollup
+	found := false
+	for _, v := range rollups {
+		if length%v.Resolution == 0 && v.Retention >= 2*length && (!found || v.Resolution < result.Resolution) {
+			result = v
+			found = true
+		}
+	}
+
+	return result, found
+}
+
+// rollup is a ring of slots like the window, except each slot covers a period of Resolution. Since
+// slots aren't cleared as time moves forward, each one records the period it holds.
+type rollup struct {
+	Rollup
+	seconds uint64        // Resolution in seconds
+	slots   []slot        // the hit totals are for the whole period
+	periods []uint64      // period+1 held by each slot, 0 when it's unused
+	names   *sectionNames // kept apart from the window's, so sections held for days don't use up its limit
+}
+
+// RollupSnapshot is the state of a rollup in a `Snapshot`. Only the slots that hold a period are kept,
+// Periods[i] is the period of Slots[i], counted in Resolution since the unix epoch.
+type RollupSnapshot struct {
+	Resolution time.Duration
+	Slots      []WindowEntry
+	Periods    []uint64
+}
+
+// SetRollups replaces the rollups kept alongside the window, there are none by default. The new
+// rollups start out empty, so they're set before entries are recorded or a snapshot is restored.
+func (ws *WebStats) SetRollups(rollups []Rollup) error {
+	if err := validateRollups(rollups); err != nil {
+		return err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.rollups = newRollups(rollups, ws.sectionNames.max)
+	return nil
+}
+
+func newRollups(rollups []Rollup, maxSections int) []*rollup {
+	result := make([]*rollup, len(rollups))
+	for i, v := range rollups {
+		seconds := uint64(v.Resolution / time.Second)
+		numSlots := uint64(v.Retention/time.Second) / seconds
+		result[i] = &rollup{
+			Rollup:  v,
+			seconds: seconds,
+			slots:   make([]slot, numSlots),
+			periods: make([]uint64, numSlots),
+			names:   newSectionNames(maxSections),
+		}
+	}
+	return result
+}
+
+func (ws *WebStats) recordRollups(entry Entry, slow bool) {
+	for _, r := range ws.rollups {
+		r.record(entry, slow)
+	}
+}
+
+func (r *rollup) record(entry Entry, slow bool) {
+	period := entry.Time / r.seconds
+	idx := period % uint64(len(r.slots))
+	if r.periods[idx] != period+1 {
+		if r.periods[idx] > period+1 {
+			return // the slot was already reused for a newer period, the entry is older than the retention
+		}
+		r.slots[idx].reset(r.names)
+		r.periods[idx] = period + 1
+	}
+
+	section := r.names.retain(entry.Section)
+	r.slots[idx].add(section, entry, r.names)
+	r.names.release(section)
+	r.slots[idx].totalHits++
+	if slow {
+		r.slots[idx].slowHits++
+	}
+}
+
+// GetRollupForRange returns copies of the entries at resolution, one for each period from begin to end
+// inclusive, with begin and end rounded down to the start of their periods. Periods that had no hits,
+// or that are no longer retained, are empty. A resolution of a second reads the per second window.
+func (ws *WebStats) GetRollupForRange(resolution time.Duration, begin, end uint64) ([]WindowEntry, error) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	if begin > end {
+		return nil, fmt.Errorf("begin %d is after end %d", begin, end)
+	}
+
+	if resolution == time.Second {
+		return ws.secondsForRange(begin, end)
+	}
+
+	for _, r := range ws.rollups {
+		if r.Resolution == resolution {
+			return r.forRange(begin, end)
+		}
+	}
+
+	return nil, fmt.Errorf("there's no rollup with a resolution of %v", resolution)
+}
+
+func (r *rollup) forRange(begin, end uint64) ([]WindowEntry, error) {
+	first, last := begin/r.seconds, end/r.seconds
+	if last-first >= uint64(len(r.slots)) {
+		return nil, fmt.Errorf("range of %v is longer than the %v rollup retention of %v", time.Duration(end-begin)*time.Second, r.Resolution, r.Retention)
+	}
+
+	result := make([]WindowEntry, 0, last-first+1)
+	for period := first; period <= last; period++ {
+		idx := period % uint64(len(r.slots))
+		if r.periods[idx] != period+1 {
+			result = append(result, WindowEntry{})
+			continue
+		}
+		result = append(result, r.slots[idx].entry(r.names))
+	}
+	return result, nil
+}
+
+// secondsForRange is like forRange for the per second window, whose slots only hold the seconds
+// within the window size up to the latest time
+func (ws *WebStats) secondsForRange(begin, end uint64) ([]WindowEntry, error) {
+	windowSize := uint64(len(ws.window))
+	if end-begin >= windowSize {
+		return nil, fmt.Errorf("range of %v is longer than the window retention of %v", time.Duration(end-begin)*time.Second, time.Duration(windowSize)*time.Second)
+	}
+
+	result := make([]WindowEntry, 0, end-begin+1)
+	for t := begin; t <= end; t++ {
+		if t > ws.latestTime || t+windowSize <= ws.latestTime {
+			result = append(result, WindowEntry{})
+			continue
+		}
+		result = append(result, ws.window[t%windowSize].entry(ws.sectionNames))
+	}
+	return result, nil
+}
+
+func (ws *WebStats) rollupSnapshots() []RollupSnapshot {
+	result := make([]RollupSnapshot, len(ws.rollups))
+	for i, r := range ws.rollups {
+		result[i] = RollupSnapshot{Resolution: r.Resolution, Slots: []WindowEntry{}, Periods: []uint64{}}
+		for j := range r.slots {
+			if r.periods[j] == 0 {
+				continue
+			}
+			result[i].Slots = append(result[i].Slots, r.slots[j].entry(r.names))
+			result[i].Periods = append(result[i].Periods, r.periods[j]-1)
+		}
+	}
+	return result
+}
+
+// restoreRollups restores the periods of the rollups with the same resolution as the ones set. The
+// retention can differ, a period lands in its slot as if it was recorded, unless a newer one is there.
+// Snapshots of rollups that aren't set are skipped, and rollups without a snapshot start out empty.
+func (ws *WebStats) restoreRollups(snapshots []RollupSnapshot) {
+	for _, s := range snapshots {
+		for _, r := range ws.rollups {
+			if r.Resolution != s.Resolution {
+				continue
+			}
+
+			for i, period := range s.Periods {
+				idx := period % uint64(len(r.slots))
+				if r.periods[idx] > period+1 {
+					continue
+				}
+				r.slots[idx].setEntry(s.Slots[i], r.names)
+				r.periods[idx] = period + 1
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package webstats
+
+// OtherSection is where hits are counted for new sections once the section limit is reached
+const OtherSection = "other"
+
+// DefaultMaxSections is the default limit of distinct sections, see `SetMaxSections`
+const DefaultMaxSections = 1000
+
+type sectionID uint32
+
+// sectionNames interns section names to small ids, so a slot keeps a 4 byte id for each section
+// instead of its own copy of the name. OtherSection is always id 0. Slots hold a reference to the ids
+// they have counts for, and a name is forgotten once no slot holds it, so its id can be reused.
+type sectionNames struct {
+	names []string
+	refs  []int       // references held to each id, OtherSection's aren't counted
+	free  []sectionID // ids of forgotten names
+	ids   map[string]sectionID
+	max   int // 0 is unlimited
+}
+
+func newSectionNames(max int) *sectionNames {
+	return &sectionNames{
+		names: []string{OtherSection},
+		refs:  []int{0},
+		ids:   map[string]sectionID{OtherSection: 0},
+		max:   max,
+	}
+}
+
+// retain returns the id of a section and holds a reference to it, interning it if it's new and there's
+// room. OtherSection isn't counted towards the limit.
+func (sn *sectionNames) retain(name string) sectionID {
+	id, ok := sn.ids[name]
+	if !ok {
+		if sn.max > 0 && len(sn.ids)-1 >= sn.max {
+			return 0
+		}
+		id = sn.intern(name)
+	}
+
+	sn.retainID(id)
+	return id
+}
+
+func (sn *sectionNames) intern(name string) sectionID {
+	name = cloneString(name)
+	if n := len(sn.free); n > 0 {
+		id := sn.free[n-1]
+		sn.free = sn.free[:n-1]
+		sn.names[id] = name
+		sn.ids[name] = id
+		return id
+	}
+
+	id := sectionID(len(sn.names))
+	sn.names = append(sn.names, name)
+	sn.refs = append(sn.refs, 0)
+	sn.ids[name] = id
+	return id
+}
+
+// retainID holds another reference to an id that's already held
+func (sn *sectionNames) retainID(id sectionID) {
+	if id != 0 {
+		sn.refs[id]++
+	}
+}
+
+// release drops a reference to an id, and forgets its name once there are none left
+func (sn *sectionNames) release(id sectionID) {
+	if id == 0 {
+		return
+	}
+
+	sn.refs[id]--
+	if sn.refs[id] == 0 {
+		delete(sn.ids, sn.names[id])
+		sn.names[id] = ""
+		sn.free = append(sn.free, id)
+	}
+}
+
+func (sn *sectionNames) name(id sectionID) string {
+	return sn.names[id]
+}
+
+// cloneString returns a copy of s. Parsed fields are slices of their whole line, so a name or key that's
+// kept would keep the line alive as well.
+func cloneString(s string) string {
+	return string([]byte(s))
+}
+
+type sectionCounts struct {
+	hits    uint64
+	traffic SectionTraffic
+}
+
+// slot is how a WindowEntry is kept: sections are interned, and the maps are kept when the slot is
+// cleared so a new second doesn't allocate them again
+type slot struct {
+	sections     map[sectionID]sectionCounts
+	clients      boundedCounts // bounded by MaxClientsPerTimeSlot
+	clientGroups map[string]uint64
+	totalHits    uint64
+	slowHits     uint64
+}
+
+// add counts an entry in the section, client and group counts of the slot. The hit totals are kept separately.
+func (s *slot) add(section sectionID, entry Entry, names *sectionNames) {
+	if s.sections == nil {
+		s.sections = map[sectionID]sectionCounts{}
+	}
+	counts, ok := s.sections[section]
+	if !ok {
+		names.retainID(section)
+	}
+	counts.hits++
+	counts.traffic.Bytes += entry.Bytes
+	counts.traffic.StatusClasses[StatusClass(entry.Status)]++
+	s.sections[section] = counts
+
+	if entry.RemoteHost != "" {
+		s.clients.increment(entry.RemoteHost, MaxClientsPerTimeSlot)
+	}
+
+	if entry.ClientGroup != "" {
+		if s.clientGroups == nil {
+			s.clientGroups = map[string]uint64{}
+		}
+		s.clientGroups[entry.ClientGroup]++
+	}
+}
+
+// reset empties the slot, keeping its maps, and releases its sections
+func (s *slot) reset(names *sectionNames) {
+	for k := range s.sections {
+		names.release(k)
+		delete(s.sections, k)
+	}
+	s.clients.reset()
+	for k := range s.clientGroups {
+		delete(s.clientGroups, k)
+	}
+	s.totalHits = 0
+	s.slowHits = 0
+}
+
+// entry returns a WindowEntry that doesn't share anything with the slot, maps are nil when they're empty
+func (s *slot) entry(names *sectionNames) WindowEntry {
+	we := WindowEntry{
+		Clients:              copyCounts(s.clients.counts),
+		InheritedClientHits:  copyCounts(s.clients.inherited),
+		ClientGroups:         copyCounts(s.clientGroups),
+		TotalHitsForTimeSlot: s.totalHits,
+		SlowHitsForTimeSlot:  s.slowHits,
+	}
+
+	if len(s.sections) > 0 {
+		we.Sections = make(map[string]uint64, len(s.sections))
+		we.SectionTraffic = make(map[string]SectionTraffic, len(s.sections))
+		for id, v := range s.sections {
+			we.Sections[names.name(id)] = v.hits
+			we.SectionTraffic[names.name(id)] = v.traffic
+		}
+	}
+
+	return we
+}
+
+// setEntry replaces the slot with a WindowEntry, e.g. from a snapshot
+func (s *slot) setEntry(we WindowEntry, names *sectionNames) {
+	s.reset(names)
+	for k, hits := range we.Sections {
+		if s.sections == nil {
+			s.sections = map[sectionID]sectionCounts{}
+		}
+
+		// sections over the limit are merged into OtherSection, the slot only holds one reference to it
+		id := names.retain(k)
+		counts, ok := s.sections[id]
+		if ok {
+			names.release(id)
+		}
+		counts.hits += hits
+		traffic := we.SectionTraffic[k]
+		counts.traffic.Bytes += traffic.Bytes
+		for i, v := range traffic.StatusClasses {
+			counts.traffic.StatusClasses[i] += v
+		}
+		s.sections[id] = counts
+	}
+
+	s.clients.counts = mergeCounts(s.clients.counts, we.Clients)
+	s.clients.inherited = mergeCounts(s.clients.inherited, we.InheritedClientHits)
+	s.clientGroups = mergeCounts(s.clientGroups, we.ClientGroups)
+	s.totalHits = we.TotalHitsForTimeSlot
+	s.slowHits = we.SlowHitsForTimeSlot
+}
+
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	result := make(map[string]uint64, len(counts))
+	for k, v := range counts {
+		result[k] = v
+	}
+	return result
+}
+
+// mergeCounts adds counts to dst, allocating it if needed
+func mergeCounts(dst, counts map[string]uint64) map[string]uint64 {
+	if len(counts) == 0 {
+		return dst
+	}
+
+	if dst == nil {
+		dst = make(map[string]uint64, len(counts))
+	}
+	for k, v := range counts {
+		dst[k] += v
+	}
+	return dst
+}
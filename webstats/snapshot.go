@@ -0,0 +1,61 @@
+package webstats
+
+import "fmt"
+
+// Snapshot is a copy of the state WebStats builds up from entries, so it can be restored after a restart.
+// Thresholds aren't included since they come from the config.
+type Snapshot struct {
+	Window               []WindowEntry
+	LatestTime           uint64
+	TotalHitsForLast2Min uint64
+	SlowHitsForLast2Min  uint64
+	LateEntries          uint64
+	Rollups              []RollupSnapshot
+}
+
+// Snapshot returns a deep copy of the recorded state
+func (ws *WebStats) Snapshot() Snapshot {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	window := make([]WindowEntry, len(ws.window))
+	for i := range ws.window {
+		window[i] = ws.window[i].entry(ws.sectionNames)
+	}
+
+	return Snapshot{
+		Window:               window,
+		LatestTime:           ws.latestTime,
+		TotalHitsForLast2Min: ws.totalHitsForLast2Min,
+		SlowHitsForLast2Min:  ws.slowHitsForLast2Min,
+		LateEntries:          ws.lateEntries,
+		Rollups:              ws.rollupSnapshots(),
+	}
+}
+
+// Restore replaces the recorded state with a snapshot. The alarms are calculated from the restored
+// totals, so an alarm that was active when the snapshot was taken is still active and doesn't re-fire.
+func (ws *WebStats) Restore(snapshot Snapshot) error {
+	if len(snapshot.Window) != ws.WindowSize() {
+		return fmt.Errorf("snapshot window size %d doesn't match the window size %d", len(snapshot.Window), ws.WindowSize())
+	}
+
+	for _, v := range snapshot.Rollups {
+		if len(v.Slots) != len(v.Periods) {
+			return fmt.Errorf("snapshot of the %v rollup has %d slots for %d periods", v.Resolution, len(v.Slots), len(v.Periods))
+		}
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for i, v := range snapshot.Window {
+		ws.window[i].setEntry(v, ws.sectionNames)
+	}
+	ws.latestTime = snapshot.LatestTime
+	ws.totalHitsForLast2Min = snapshot.TotalHitsForLast2Min
+	ws.slowHitsForLast2Min = snapshot.SlowHitsForLast2Min
+	ws.lateEntries = snapshot.LateEntries
+	ws.restoreRollups(snapshot.Rollups)
+	return nil
+}
@@ -3,7 +3,11 @@
  */
 package webstats
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+	"time"
+)
 
 const twoMinutes = 120 // 2 minutes
 
@@ -11,31 +15,124 @@ const twoMinutes = 120 // 2 minutes
 //  can be initialized to.
 const MinWindowSize = twoMinutes // 2 minutes
 
-// MaxWindowSize is the max allowed retention of webStats
-const MaxWindowSize = (1 << 20) // 1MB
+// MaxWindowSize is the max allowed retention of webStats in seconds, one slot is kept for each second
+const MaxWindowSize = (1 << 20) // a little over 12 days
+
+// DefaultWindowSize is the retention the agent uses unless it's configured, longer ranges are read from
+// rollups, see `SetRollups`
+const DefaultWindowSize = 10 * 60 // 10 minutes
+
+// MaxWindowRetention is MaxWindowSize as a duration
+const MaxWindowRetention = MaxWindowSize * time.Second
 
 // WindowEntry holds section data and total hits for a given time entry
 type WindowEntry struct {
 	Sections             map[string]uint64
+	SectionTraffic       map[string]SectionTraffic // bytes and status classes per section
+	Clients              map[string]uint64         // hits per remote host, bounded by MaxClientsPerTimeSlot
+	InheritedClientHits  map[string]uint64         // hits in Clients that a host took over from the one it replaced
+	ClientGroups         map[string]uint64         // hits per client group label
 	TotalHitsForTimeSlot uint64
+	SlowHitsForTimeSlot  uint64
+}
+
+// SectionTraffic holds the bytes sent and the hits per status class for a section
+type SectionTraffic struct {
+	Bytes         uint64
+	StatusClasses [6]uint64 // 1 to 5 are hits for 1xx to 5xx, 0 is anything else
 }
 
-// WebStats keeps track of apache server log stats
+// StatusClass returns the index in StatusClasses for a status code
+func StatusClass(status uint64) int {
+	if status < 100 || status >= 600 {
+		return 0
+	}
+	return int(status / 100)
+}
+
+// Entry holds the parts of a log line that WebStats records
+type Entry struct {
+	Section     string
+	RemoteHost  string
+	ClientGroup string // label from the CIDR groups in config, "" if ungrouped
+	Time        uint64
+	Latency     uint64 // microseconds taken to serve the request, 0 if not logged
+	Status      uint64
+	Bytes       uint64
+}
+
+// WebStats keeps track of apache server log stats. It's safe to read stats from other go routines
+// while entries are being recorded, the exported methods take care of locking.
 type WebStats struct {
-	window                []WindowEntry
+	mu                    sync.RWMutex
+	window                []slot
+	sectionNames          *sectionNames
+	rollups               []*rollup
 	isTotalTrafficAlerted bool
 	totalTrafficThreshold uint
 	latestTime            uint64
 	totalHitsForLast2Min  uint64
+	slowHitsForLast2Min   uint64
+	latencyThreshold      uint64 // microseconds, 0 disables the latency alarm
+	slowPercentThreshold  float64
+	rateLimit             uint64 // max hits per client over rateLimitSeconds, 0 disables rate limit checks
+	rateLimitSeconds      uint64
+	lateDataPolicy        LateDataPolicy
+	lateEntries           uint64
+}
+
+// LateDataPolicy decides what happens to entries that are older than the window
+type LateDataPolicy int
+
+const (
+	// DropLateData leaves out entries older than the window, they're only counted in `LateEntries`
+	DropLateData LateDataPolicy = iota
+	// ClampLateData records entries older than the window at the oldest time still in the window
+	ClampLateData
+)
+
+// ParseLateDataPolicy converts "drop" or "clamp" to a LateDataPolicy, "" defaults to drop
+func ParseLateDataPolicy(policy string) (LateDataPolicy, error) {
+	switch policy {
+	case "", "drop":
+		return DropLateData, nil
+	case "clamp":
+		return ClampLateData, nil
+	}
+
+	return DropLateData, fmt.Errorf("%q is an invalid late data policy, expected drop or clamp", policy)
+}
+
+func (p LateDataPolicy) String() string {
+	if p == ClampLateData {
+		return "clamp"
+	}
+	return "drop"
+}
+
+// SetLateDataPolicy sets how entries older than the window are handled, it defaults to DropLateData
+func (ws *WebStats) SetLateDataPolicy(policy LateDataPolicy) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.lateDataPolicy = policy
+}
+
+// LateEntries returns how many entries were older than the window when they were recorded
+func (ws *WebStats) LateEntries() uint64 {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.lateEntries
 }
 
 // WindowSize returns length of window
 func (ws *WebStats) WindowSize() int {
-	return len(ws.window)
+	return len(ws.window) // the window is never resized, so no lock is needed
 }
 
 // TotalHitsForLast2Min returns the total hits within the window
 func (ws *WebStats) TotalHitsForLast2Min() uint64 {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
 	return ws.totalHitsForLast2Min
 }
 
@@ -43,35 +140,87 @@ func (ws *WebStats) setTotalHitsForLast2Min(hits uint64) {
 	ws.totalHitsForLast2Min = hits
 }
 
+// SlowHitsForLast2Min returns the hits slower than the latency threshold within the window
+func (ws *WebStats) SlowHitsForLast2Min() uint64 {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.slowHitsForLast2Min
+}
+
+func (ws *WebStats) setSlowHitsForLast2Min(hits uint64) {
+	ws.slowHitsForLast2Min = hits
+}
+
 // HitsAtTime gets the hits at time provided
 func (ws *WebStats) HitsAtTime(date uint64) uint64 {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.hitsAtTime(date)
+}
+
+func (ws *WebStats) hitsAtTime(date uint64) uint64 {
 	idx := date % uint64(ws.WindowSize())
-	return ws.window[idx].TotalHitsForTimeSlot
+	return ws.window[idx].totalHits
 }
 
 func (ws *WebStats) setHitsAtTime(date, val uint64) {
 	idx := date % uint64(ws.WindowSize())
-	ws.window[idx].TotalHitsForTimeSlot = val
+	ws.window[idx].totalHits = val
+}
+
+// SlowHitsAtTime gets the hits slower than the latency threshold at time provided
+func (ws *WebStats) SlowHitsAtTime(date uint64) uint64 {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.slowHitsAtTime(date)
+}
+
+func (ws *WebStats) slowHitsAtTime(date uint64) uint64 {
+	idx := date % uint64(ws.WindowSize())
+	return ws.window[idx].slowHits
+}
+
+func (ws *WebStats) setSlowHitsAtTime(date, val uint64) {
+	idx := date % uint64(ws.WindowSize())
+	ws.window[idx].slowHits = val
 }
 
 func (ws *WebStats) clearHitsAtTime(date uint64) {
 	idx := date % uint64(ws.WindowSize())
-	ws.window[idx] = WindowEntry{}
+	ws.window[idx].reset(ws.sectionNames)
 }
 
 // LatestTime gets the latest time recorded
 func (ws *WebStats) LatestTime() uint64 {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
 	return ws.latestTime
 }
 
-// GetWindowForRange returns the window for begin and end inclusive
+// GetWindowForRange returns a copy of the window for begin and end inclusive. Entries are deep copied,
+// so they can be read while new entries are recorded.
 func (ws *WebStats) GetWindowForRange(curTime uint64, secondsBefore uint64) []WindowEntry {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	window := ws.windowForRange(curTime, secondsBefore)
+	result := make([]WindowEntry, len(window))
+	for i := range window {
+		result[i] = window[i].entry(ws.sectionNames)
+	}
+
+	return result
+}
+
+// windowForRange returns the window for begin and end inclusive, the slices alias the live window
+func (ws *WebStats) windowForRange(curTime uint64, secondsBefore uint64) []slot {
 	beginRange := curTime - secondsBefore
 	windowSize := uint64(len(ws.window))
 	endIdx := (curTime + 1) % windowSize // endIdx is exclusive when mapping slices
 	beginIdx := beginRange % windowSize
 
-	if endIdx < beginIdx {
+	// when the range is the whole window, begin and end are the same slot
+	if endIdx <= beginIdx {
 		return append(ws.window[beginIdx:], ws.window[:endIdx]...)
 	}
 
@@ -83,64 +232,187 @@ func (ws *WebStats) setLatestTime(date uint64) {
 }
 
 // InitWebStats safely initializes WebStats
-func InitWebStats(windowSize, totalTrafficThreshold uint, startTime uint64) (WebStats, error) {
+func InitWebStats(windowSize, totalTrafficThreshold uint, startTime uint64) (*WebStats, error) {
 	if totalTrafficThreshold == 0 {
-		return WebStats{}, fmt.Errorf("%d is an invalid threshold", totalTrafficThreshold)
+		return nil, fmt.Errorf("%d is an invalid threshold", totalTrafficThreshold)
 	}
 
 	if windowSize < MinWindowSize {
-		return WebStats{}, fmt.Errorf("%d is an invalid window size", windowSize)
+		return nil, fmt.Errorf("%d is an invalid window size", windowSize)
 	}
 
-	return WebStats{
+	return &WebStats{
 		totalTrafficThreshold: totalTrafficThreshold,
-		window:                make([]WindowEntry, int(windowSize)),
+		window:                make([]slot, int(windowSize)),
+		sectionNames:          newSectionNames(DefaultMaxSections),
 		latestTime:            startTime,
 	}, nil
 }
 
+// EnableLatencyAlarm turns on the latency alarm. Requests slower than latencyThreshold (in microseconds)
+// are counted as slow, and the alarm triggers when more than slowPercent of the requests over 2 mins are slow.
+func (ws *WebStats) EnableLatencyAlarm(latencyThreshold uint64, slowPercent float64) error {
+	if latencyThreshold == 0 {
+		return fmt.Errorf("%d is an invalid latency threshold", latencyThreshold)
+	}
+
+	if slowPercent <= 0 || slowPercent > 100 {
+		return fmt.Errorf("%g is an invalid slow request percentage", slowPercent)
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.latencyThreshold = latencyThreshold
+	ws.slowPercentThreshold = slowPercent
+	return nil
+}
+
+// DisableLatencyAlarm turns off the latency alarm, slow hits already recorded are kept
+func (ws *WebStats) DisableLatencyAlarm() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.latencyThreshold = 0
+}
+
+// SetTotalTrafficThreshold changes the average hits per second that triggers the 2 min alarm
+func (ws *WebStats) SetTotalTrafficThreshold(totalTrafficThreshold uint) error {
+	if totalTrafficThreshold == 0 {
+		return fmt.Errorf("%d is an invalid threshold", totalTrafficThreshold)
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.totalTrafficThreshold = totalTrafficThreshold
+	return nil
+}
+
 // AddEntry adds an entry and updates statistics
 func (ws *WebStats) AddEntry(sectionName string, timeInSeconds uint64) {
-	ws.updateStats(timeInSeconds)
-	curIdx := timeInSeconds % uint64(len(ws.window))
-	if ws.window[curIdx].Sections == nil {
-		ws.window[curIdx].Sections = map[string]uint64{}
+	ws.Record(Entry{Section: sectionName, Time: timeInSeconds})
+}
+
+// Record adds a log entry and updates statistics
+func (ws *WebStats) Record(entry Entry) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	slow := ws.isSlow(entry.Latency)
+	ws.recordRollups(entry, slow) // before late data is dropped or clamped, since rollups are kept for longer
+	// held until the entry is recorded, so slots that are reset on the way don't forget it
+	section := ws.sectionNames.retain(entry.Section)
+	defer ws.sectionNames.release(section)
+
+	// the slot for an entry older than the window has already been reused for a newer time
+	if entry.Time+uint64(len(ws.window)) <= ws.latestTime {
+		ws.lateEntries++
+		if ws.lateDataPolicy == DropLateData {
+			return
+		}
+		entry.Time = ws.latestTime - uint64(len(ws.window)) + 1
+	}
+
+	ws.updateStats(entry.Time, slow)
+	ws.window[entry.Time%uint64(len(ws.window))].add(section, entry, ws.sectionNames)
+}
+
+// SetMaxSections limits how many distinct sections are kept, hits for new sections are counted under
+// OtherSection while the limit is reached. The limit applies to the sections the window still holds,
+// so scanners requesting random paths can't use up memory, and new sections are kept again once theirs
+// have aged out. Each rollup keeps its names apart with the same limit, so sections it holds for days
+// don't take room in the window. 0 removes the limit.
+func (ws *WebStats) SetMaxSections(max int) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.sectionNames.max = max
+	for _, r := range ws.rollups {
+		r.names.max = max
 	}
-	section := ws.window[curIdx].Sections[sectionName]
-	ws.window[curIdx].Sections[sectionName] = section + 1
 }
 
 // HasTotalTrafficAlarm returns whether alarm is alerted
 func (ws *WebStats) HasTotalTrafficAlarm() bool {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
 	// To calcuate whether we've exceeded the average threshold allowed, we have to use multiplication
 	//   of the threshold by the window size, since dividing integers can result in loss of data.
 	return ws.totalHitsForLast2Min > uint64(ws.totalTrafficThreshold*twoMinutes)
 }
 
-func (ws *WebStats) updateStats(timeInSeconds uint64) {
-	currentTotalHitsForLast2Min := ws.TotalHitsForLast2Min()
+// HasLatencyAlarm returns whether too many requests over the last 2 mins were slower than the latency threshold
+func (ws *WebStats) HasLatencyAlarm() bool {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	if ws.latencyThreshold == 0 {
+		return false
+	}
+
+	// same as above, multiply instead of dividing so that the percentage isn't truncated
+	return float64(ws.slowHitsForLast2Min)*100 > ws.slowPercentThreshold*float64(ws.totalHitsForLast2Min)
+}
 
-	if ws.LatestTime() < timeInSeconds {
-		if ws.LatestTime() <= timeInSeconds-uint64(twoMinutes) {
-			// if no hits have come in for the last two minutes, reset counter
-			currentTotalHitsForLast2Min = 0
-		} else {
-			// subtract hits from 2 mins ago, since now we have a new latest time
-			currentTotalHitsForLast2Min = currentTotalHitsForLast2Min - ws.HitsAtTime(timeInSeconds-twoMinutes)
+func (ws *WebStats) isSlow(latency uint64) bool {
+	return ws.latencyThreshold > 0 && latency > ws.latencyThreshold
+}
+
+func (ws *WebStats) updateStats(timeInSeconds uint64, slow bool) {
+	if ws.latestTime < timeInSeconds {
+		ws.advanceTo(timeInSeconds)
+	}
+
+	hitsForCurrentTime := ws.hitsAtTime(timeInSeconds)
+	ws.setHitsAtTime(timeInSeconds, hitsForCurrentTime+1)
+	if slow {
+		ws.setSlowHitsAtTime(timeInSeconds, ws.slowHitsAtTime(timeInSeconds)+1)
+	}
+
+	// late entries older than 2 mins are still kept in the window for interval stats,
+	//   but they no longer count towards the alarms
+	if timeInSeconds+twoMinutes > ws.latestTime {
+		ws.setTotalHitsForLast2Min(ws.totalHitsForLast2Min + 1)
+		if slow {
+			ws.setSlowHitsForLast2Min(ws.slowHitsForLast2Min + 1)
 		}
+	}
+}
+
+// AdvanceTo moves the latest time forward without recording an entry, e.g. to expire old hits when the
+// log is quiet. Times before the latest time are ignored.
+func (ws *WebStats) AdvanceTo(timeInSeconds uint64) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.latestTime < timeInSeconds {
+		ws.advanceTo(timeInSeconds)
+	}
+}
 
-		// have to zero out entries in window for any gaps between latest time recorded and current time.
-		//   Otherwise, stale calculations for the previous window could be left behind and cause future
-		//   calculations to be wrong.
-		for i := ws.LatestTime() + 1; i <= timeInSeconds; i++ {
-			ws.setHitsAtTime(i, 0)
-			ws.clearHitsAtTime(i)
+// advanceTo moves the latest time forward, removing hits that are no longer within the last 2 mins from the totals
+func (ws *WebStats) advanceTo(timeInSeconds uint64) {
+	if ws.latestTime+twoMinutes <= timeInSeconds {
+		// if no hits have come in for the last two minutes, reset counter
+		ws.setTotalHitsForLast2Min(0)
+		ws.setSlowHitsForLast2Min(0)
+	} else {
+		// subtract hits from 2 mins ago for every second we move forward, not just the latest one,
+		//   so that a gap in the log doesn't leave stale hits in the totals
+		for i := ws.latestTime + 1; i <= timeInSeconds; i++ {
+			ws.setTotalHitsForLast2Min(ws.totalHitsForLast2Min - ws.hitsAtTime(i-twoMinutes))
+			ws.setSlowHitsForLast2Min(ws.slowHitsForLast2Min - ws.slowHitsAtTime(i-twoMinutes))
 		}
+	}
 
-		ws.setLatestTime(timeInSeconds)
+	// have to zero out entries in window for any gaps between latest time recorded and current time.
+	//   Otherwise, stale calculations for the previous window could be left behind and cause future
+	//   calculations to be wrong. There's no need to clear more than the whole window once though.
+	clearFrom := ws.latestTime + 1
+	if windowSize := uint64(len(ws.window)); timeInSeconds-ws.latestTime > windowSize {
+		clearFrom = timeInSeconds - windowSize + 1
+	}
+	for i := clearFrom; i <= timeInSeconds; i++ {
+		ws.clearHitsAtTime(i)
 	}
 
-	hitsForCurrentTime := ws.HitsAtTime(timeInSeconds)
-	ws.setHitsAtTime(timeInSeconds, hitsForCurrentTime+1)
-	ws.setTotalHitsForLast2Min(currentTotalHitsForLast2Min + 1)
+	ws.setLatestTime(timeInSeconds)
 }
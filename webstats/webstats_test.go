@@ -1,7 +1,9 @@
 package webstats_test
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hardboiled/apache-log-parser/webstats"
 	. "github.com/onsi/ginkgo"
@@ -14,12 +16,14 @@ func TestWebStats(t *testing.T) {
 }
 
 var _ = Describe("WebStats", func() {
-	var ws webstats.WebStats
+	var ws *webstats.WebStats
 	var startTime uint64
 
 	BeforeEach(func() {
 		startTime = 1549574340
 		ws, _ = webstats.InitWebStats(120, 10, startTime)
+		rollups, _ := webstats.ParseRollups(webstats.DefaultRollups)
+		ws.SetRollups(rollups)
 	})
 
 	It("adds entry to right section", func() {
@@ -39,6 +43,16 @@ var _ = Describe("WebStats", func() {
 		Expect(entry.Sections[section2]).To(Equal(uint64(2)))
 	})
 
+	It("records bytes and status classes per section", func() {
+		ws.Record(webstats.Entry{Section: "/api", Time: startTime, Status: 200, Bytes: 100})
+		ws.Record(webstats.Entry{Section: "/api", Time: startTime, Status: 503, Bytes: 50})
+		ws.Record(webstats.Entry{Section: "/api", Time: startTime, Status: 0})
+
+		traffic := ws.GetWindowForRange(startTime, 0)[0].SectionTraffic["/api"]
+		Expect(traffic.Bytes).To(Equal(uint64(150)))
+		Expect(traffic.StatusClasses).To(Equal([6]uint64{1, 0, 1, 0, 0, 1}))
+	})
+
 	It("triggers 2 min alarm properly", func() {
 		localWs, _ := webstats.InitWebStats(120, 2, startTime)
 		section1 := "a"
@@ -57,4 +71,426 @@ var _ = Describe("WebStats", func() {
 		localWs.AddEntry(section2, startTime+uint64(120))
 		Expect(localWs.HasTotalTrafficAlarm()).To(Equal(false))
 	})
+
+	It("triggers latency alarm properly", func() {
+		localWs, _ := webstats.InitWebStats(120, 10, startTime)
+		Expect(localWs.EnableLatencyAlarm(500000, 1)).To(BeNil())
+
+		for i := 0; i < 99; i++ {
+			localWs.Record(webstats.Entry{Section: "a", Time: startTime, Latency: 1000})
+		}
+		localWs.Record(webstats.Entry{Section: "a", Time: startTime, Latency: 600000})
+		Expect(localWs.SlowHitsForLast2Min()).To(Equal(uint64(1)))
+		Expect(localWs.HasLatencyAlarm()).To(Equal(false))
+
+		localWs.Record(webstats.Entry{Section: "a", Time: startTime + 1, Latency: 600000})
+		Expect(localWs.HasLatencyAlarm()).To(Equal(true))
+
+		// slow requests age out of the 2 min window
+		localWs.Record(webstats.Entry{Section: "a", Time: startTime + 121, Latency: 1000})
+		Expect(localWs.SlowHitsForLast2Min()).To(Equal(uint64(0)))
+		Expect(localWs.HasLatencyAlarm()).To(Equal(false))
+	})
+
+	It("rejects invalid latency alarm settings", func() {
+		Expect(ws.EnableLatencyAlarm(0, 1)).ToNot(BeNil())
+		Expect(ws.EnableLatencyAlarm(500000, 0)).ToNot(BeNil())
+		Expect(ws.EnableLatencyAlarm(500000, 101)).ToNot(BeNil())
+		Expect(ws.HasLatencyAlarm()).To(Equal(false))
+	})
+
+	It("keeps client counts bounded while tracking heavy hitters", func() {
+		for i := 0; i < 50; i++ {
+			ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.1", Time: startTime})
+		}
+		for i := 0; i < webstats.MaxClientsPerTimeSlot*2; i++ {
+			ws.Record(webstats.Entry{Section: "a", RemoteHost: fmt.Sprintf("192.168.0.%d", i), Time: startTime})
+		}
+
+		entry := ws.GetWindowForRange(startTime, 0)[0]
+		Expect(len(entry.Clients)).To(Equal(webstats.MaxClientsPerTimeSlot))
+		Expect(entry.Clients["10.0.0.1"]).To(Equal(uint64(50)))
+
+		// an evicted client's hits are inherited by the one replacing it, so none are lost
+		total := uint64(0)
+		for _, hits := range entry.Clients {
+			total += hits
+		}
+		Expect(total).To(Equal(uint64(50 + webstats.MaxClientsPerTimeSlot*2)))
+	})
+
+	It("finds clients over the rate limit", func() {
+		Expect(ws.EnableRateLimit(4, 2)).To(BeNil())
+		for i := uint64(0); i < 3; i++ {
+			ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.1", Time: startTime + i})
+			ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.1", Time: startTime + i})
+			ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.2", Time: startTime + i})
+		}
+		ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.1", Time: startTime + 2})
+
+		Expect(ws.RateLimitOffenders(startTime + 1)).To(BeEmpty())
+		Expect(ws.RateLimitOffenders(startTime + 2)).To(Equal([]webstats.ClientHits{{RemoteHost: "10.0.0.1", Hits: 5}}))
+	})
+
+	It("finds clients over the rate limit for a period as long as the window", func() {
+		Expect(ws.EnableRateLimit(4, uint64(ws.WindowSize()))).To(BeNil())
+		for i := uint64(0); i < 5; i++ {
+			ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.1", Time: startTime + i*29})
+		}
+
+		Expect(ws.GetWindowForRange(startTime+119, 119)).To(HaveLen(120))
+		Expect(ws.RateLimitOffenders(startTime + 119)).To(Equal([]webstats.ClientHits{{RemoteHost: "10.0.0.1", Hits: 5}}))
+	})
+
+	It("doesn't count inherited hits towards the rate limit", func() {
+		Expect(ws.EnableRateLimit(10, 2)).To(BeNil())
+		for i := 0; i < 50; i++ {
+			ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.1", Time: startTime})
+		}
+		for i := 0; i < webstats.MaxClientsPerTimeSlot-1; i++ {
+			for j := 0; j < 10; j++ {
+				ws.Record(webstats.Entry{Section: "a", RemoteHost: fmt.Sprintf("192.168.0.%d", i), Time: startTime})
+			}
+		}
+		// the slot is full, so this host replaces one with 10 hits and is counted with 11
+		ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.2", Time: startTime})
+		// and this one does too, but it's over the limit with its own hits
+		for i := 0; i < 16; i++ {
+			ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.3", Time: startTime})
+		}
+		ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.1", Time: startTime + 1})
+
+		entry := ws.GetWindowForRange(startTime, 0)[0]
+		Expect(entry.Clients["10.0.0.2"]).To(Equal(uint64(11)))
+		Expect(entry.InheritedClientHits).To(Equal(map[string]uint64{"10.0.0.2": 10, "10.0.0.3": 10}))
+		Expect(ws.RateLimitOffenders(startTime + 1)).To(Equal([]webstats.ClientHits{
+			{RemoteHost: "10.0.0.1", Hits: 51},
+			{RemoteHost: "10.0.0.3", Hits: 16, Uncertain: 10},
+		}))
+	})
+
+	It("rejects rate limit periods larger than the window", func() {
+		Expect(ws.EnableRateLimit(5, uint64(ws.WindowSize()+1))).ToNot(BeNil())
+		Expect(ws.RateLimitOffenders(startTime)).To(BeNil())
+	})
+
+	It("returns windows that don't alias the live window", func() {
+		ws.AddEntry("a", startTime)
+		entry := ws.GetWindowForRange(startTime, 0)[0]
+		entry.Sections["a"] = 100
+
+		ws.AddEntry("a", startTime)
+		Expect(ws.GetWindowForRange(startTime, 0)[0].Sections["a"]).To(Equal(uint64(2)))
+		Expect(entry.Sections["a"]).To(Equal(uint64(100)))
+	})
+
+	It("can be read while entries are recorded", func() {
+		done := make(chan bool)
+		go func() {
+			defer close(done)
+			for i := uint64(0); i < 1000; i++ {
+				ws.Record(webstats.Entry{Section: "a", RemoteHost: "10.0.0.1", Time: startTime + i/10})
+			}
+		}()
+
+		for reading := true; reading; {
+			select {
+			case <-done:
+				reading = false
+			default:
+				latest := ws.LatestTime()
+				for _, v := range ws.GetWindowForRange(latest, 9) {
+					Expect(v.Sections["a"]).To(BeNumerically("<=", 10))
+				}
+				ws.HasTotalTrafficAlarm()
+			}
+		}
+
+		Expect(ws.TotalHitsForLast2Min()).To(Equal(uint64(1000)))
+	})
+
+	It("removes hits older than 2 mins from the total across gaps in the log", func() {
+		for i := 0; i < 5; i++ {
+			ws.AddEntry("a", startTime)
+		}
+		ws.AddEntry("a", startTime+60)
+		ws.AddEntry("a", startTime+125)
+
+		Expect(ws.TotalHitsForLast2Min()).To(Equal(uint64(2)))
+	})
+
+	It("drops late entries older than the window", func() {
+		ws.AddEntry("a", startTime+200)
+		ws.AddEntry("a", startTime+50)
+
+		Expect(ws.LateEntries()).To(Equal(uint64(1)))
+		Expect(ws.TotalHitsForLast2Min()).To(Equal(uint64(1)))
+		Expect(ws.HitsAtTime(startTime + 200)).To(Equal(uint64(1)))
+	})
+
+	It("clamps late entries older than the window when configured", func() {
+		ws.SetLateDataPolicy(webstats.ClampLateData)
+		ws.AddEntry("a", startTime+200)
+		ws.AddEntry("a", startTime+50)
+
+		Expect(ws.LateEntries()).To(Equal(uint64(1)))
+		Expect(ws.HitsAtTime(startTime + 81)).To(Equal(uint64(1)))
+		Expect(ws.GetWindowForRange(startTime+81, 0)[0].Sections["a"]).To(Equal(uint64(1)))
+	})
+
+	It("keeps late entries older than 2 mins in the window without counting them for alarms", func() {
+		localWs, _ := webstats.InitWebStats(240, 10, startTime)
+		localWs.AddEntry("a", startTime+200)
+		localWs.AddEntry("a", startTime+50)
+
+		Expect(localWs.LateEntries()).To(Equal(uint64(0)))
+		Expect(localWs.HitsAtTime(startTime + 50)).To(Equal(uint64(1)))
+		Expect(localWs.TotalHitsForLast2Min()).To(Equal(uint64(1)))
+	})
+
+	It("restores a snapshot with its alarm still active", func() {
+		localWs, _ := webstats.InitWebStats(120, 1, startTime)
+		for i := 0; i < 121; i++ {
+			localWs.AddEntry("a", startTime+10)
+		}
+		Expect(localWs.HasTotalTrafficAlarm()).To(Equal(true))
+
+		restored, _ := webstats.InitWebStats(120, 1, startTime)
+		Expect(restored.Restore(localWs.Snapshot())).To(BeNil())
+		Expect(restored.HasTotalTrafficAlarm()).To(Equal(true))
+		Expect(restored.LatestTime()).To(Equal(startTime + 10))
+		Expect(restored.GetWindowForRange(startTime+10, 0)[0].Sections["a"]).To(Equal(uint64(121)))
+
+		// the hits still expire once they're older than 2 mins
+		restored.AdvanceTo(startTime + 130)
+		Expect(restored.HasTotalTrafficAlarm()).To(Equal(false))
+	})
+
+	It("accepts windows from MinWindowSize up to MaxWindowSize", func() {
+		_, err := webstats.InitWebStats(webstats.MinWindowSize-1, 10, startTime)
+		Expect(err).ToNot(BeNil())
+		localWs, err := webstats.InitWebStats(7200, 10, startTime)
+		Expect(err).To(BeNil())
+		Expect(localWs.WindowSize()).To(Equal(7200))
+	})
+
+	It("rejects snapshots with a different window size", func() {
+		localWs, _ := webstats.InitWebStats(240, 10, startTime)
+		Expect(ws.Restore(localWs.Snapshot())).ToNot(BeNil())
+	})
+
+	Describe("sections", func() {
+		It("counts sections over the limit as other", func() {
+			ws.SetMaxSections(2)
+			ws.Record(webstats.Entry{Section: "/a", Time: startTime, Bytes: 1})
+			ws.Record(webstats.Entry{Section: "/b", Time: startTime, Bytes: 1})
+			ws.Record(webstats.Entry{Section: "/c", Time: startTime, Bytes: 1})
+			ws.Record(webstats.Entry{Section: "/d", Time: startTime + 1, Bytes: 1})
+			ws.Record(webstats.Entry{Section: "/a", Time: startTime + 1, Bytes: 1})
+
+			window := ws.GetWindowForRange(startTime+1, 1)
+			Expect(window[0].Sections).To(Equal(map[string]uint64{"/a": 1, "/b": 1, webstats.OtherSection: 1}))
+			Expect(window[1].Sections).To(Equal(map[string]uint64{"/a": 1, webstats.OtherSection: 1}))
+			Expect(window[1].SectionTraffic[webstats.OtherSection].Bytes).To(Equal(uint64(1)))
+			Expect(ws.HitsAtTime(startTime)).To(Equal(uint64(3)))
+
+			minutes, err := ws.GetRollupForRange(time.Minute, startTime, startTime)
+			Expect(err).To(BeNil())
+			Expect(minutes[0].Sections[webstats.OtherSection]).To(Equal(uint64(2)))
+		})
+
+		It("doesn't limit sections when the limit is 0", func() {
+			ws.SetMaxSections(0)
+			for i := 0; i < webstats.DefaultMaxSections+1; i++ {
+				ws.AddEntry(fmt.Sprintf("/%d", i), startTime)
+			}
+			Expect(ws.GetWindowForRange(startTime, 0)[0].Sections).To(HaveLen(webstats.DefaultMaxSections + 1))
+		})
+
+		It("merges sections over the limit into other when restoring a snapshot", func() {
+			ws.AddEntry("/a", startTime)
+			ws.AddEntry("/b", startTime)
+
+			restored, _ := webstats.InitWebStats(120, 10, startTime)
+			restored.SetMaxSections(1)
+			Expect(restored.Restore(ws.Snapshot())).To(BeNil())
+
+			sections := restored.GetWindowForRange(startTime, 0)[0].Sections
+			Expect(sections).To(HaveLen(2))
+			Expect(sections[webstats.OtherSection]).To(Equal(uint64(1)))
+		})
+
+		It("only counts sections the window still holds towards the limit", func() {
+			localWs, _ := webstats.InitWebStats(120, 10, startTime)
+			localWs.SetMaxSections(1)
+			localWs.AddEntry("/a", startTime)
+			localWs.AddEntry("/b", startTime)
+			localWs.AddEntry("/c", startTime-200) // late and dropped, so it isn't held either
+			Expect(localWs.GetWindowForRange(startTime, 0)[0].Sections).To(Equal(map[string]uint64{"/a": 1, webstats.OtherSection: 1}))
+
+			// once /a's second is reused, there's room for /b
+			localWs.AdvanceTo(startTime + 120)
+			localWs.AddEntry("/b", startTime+120)
+			localWs.AddEntry("/a", startTime+120)
+			Expect(localWs.GetWindowForRange(startTime+120, 0)[0].Sections).To(Equal(map[string]uint64{"/b": 1, webstats.OtherSection: 1}))
+
+			// sections held by a rollup don't count towards the window's limit once they've rolled out of it
+			Expect(localWs.SetRollups([]webstats.Rollup{{Resolution: time.Minute, Retention: time.Hour}})).To(BeNil())
+			localWs.AddEntry("/b", startTime+240)
+			localWs.AdvanceTo(startTime + 360)
+			localWs.AddEntry("/a", startTime+360)
+			localWs.AddEntry("/c", startTime+360)
+			Expect(localWs.GetWindowForRange(startTime+360, 0)[0].Sections).To(Equal(map[string]uint64{"/a": 1, webstats.OtherSection: 1}))
+
+			// the rollup keeps its own names under the same limit, /b is still held there
+			minutes, err := localWs.GetRollupForRange(time.Minute, startTime+240, startTime+360)
+			Expect(err).To(BeNil())
+			Expect(minutes[0].Sections).To(Equal(map[string]uint64{"/b": 1}))
+			Expect(minutes[len(minutes)-1].Sections).To(Equal(map[string]uint64{webstats.OtherSection: 2}))
+		})
+
+		It("clears reused slots", func() {
+			ws.Record(webstats.Entry{Section: "/a", RemoteHost: "10.0.0.1", ClientGroup: "internal", Time: startTime})
+			ws.Record(webstats.Entry{Section: "/b", Time: startTime + 120})
+
+			entry := ws.GetWindowForRange(startTime+120, 0)[0]
+			Expect(entry.Sections).To(Equal(map[string]uint64{"/b": 1}))
+			Expect(entry.Clients).To(BeNil())
+			Expect(entry.ClientGroups).To(BeNil())
+			Expect(entry.TotalHitsForTimeSlot).To(Equal(uint64(1)))
+		})
+	})
+
+	Describe("rollups", func() {
+		It("rolls entries up by minute and by hour", func() {
+			ws.AddEntry("a", startTime)
+			ws.AddEntry("a", startTime+30)
+			ws.AddEntry("b", startTime+60)
+			ws.AddEntry("b", startTime+61)
+
+			minutes, err := ws.GetRollupForRange(time.Minute, startTime, startTime+119)
+			Expect(err).To(BeNil())
+			Expect(minutes).To(HaveLen(2))
+			Expect(minutes[0].Sections).To(Equal(map[string]uint64{"a": 2}))
+			Expect(minutes[0].TotalHitsForTimeSlot).To(Equal(uint64(2)))
+			Expect(minutes[1].Sections).To(Equal(map[string]uint64{"b": 2}))
+
+			hours, err := ws.GetRollupForRange(time.Hour, startTime, startTime+61)
+			Expect(err).To(BeNil())
+			Expect(hours).To(HaveLen(1))
+			Expect(hours[0].TotalHitsForTimeSlot).To(Equal(uint64(4)))
+		})
+
+		It("keeps entries that are older than the per second window", func() {
+			ws.AddEntry("a", startTime+1000)
+			ws.AddEntry("a", startTime)
+			Expect(ws.LateEntries()).To(Equal(uint64(1)))
+
+			minutes, err := ws.GetRollupForRange(time.Minute, startTime, startTime)
+			Expect(err).To(BeNil())
+			Expect(minutes[0].TotalHitsForTimeSlot).To(Equal(uint64(1)))
+		})
+
+		It("reuses slots for newer periods once they're past the retention", func() {
+			ws.AddEntry("a", startTime)
+			ws.AddEntry("b", startTime+24*60*60)
+			ws.AddEntry("a", startTime) // older than the retention, so it's left out
+
+			minutes, err := ws.GetRollupForRange(time.Minute, startTime, startTime)
+			Expect(err).To(BeNil())
+			Expect(minutes[0].TotalHitsForTimeSlot).To(Equal(uint64(0)))
+
+			minutes, err = ws.GetRollupForRange(time.Minute, startTime+24*60*60, startTime+24*60*60)
+			Expect(err).To(BeNil())
+			Expect(minutes[0].Sections).To(Equal(map[string]uint64{"b": 1}))
+		})
+
+		It("reads the per second window at a resolution of a second", func() {
+			ws.AddEntry("a", startTime)
+			seconds, err := ws.GetRollupForRange(time.Second, startTime-1, startTime+1)
+			Expect(err).To(BeNil())
+			Expect(seconds).To(HaveLen(3))
+			Expect(seconds[0].TotalHitsForTimeSlot).To(Equal(uint64(0)))
+			Expect(seconds[1].Sections).To(Equal(map[string]uint64{"a": 1}))
+			Expect(seconds[2].TotalHitsForTimeSlot).To(Equal(uint64(0)))
+		})
+
+		It("rejects unknown resolutions and ranges longer than the retention", func() {
+			_, err := ws.GetRollupForRange(10*time.Second, startTime, startTime)
+			Expect(err).ToNot(BeNil())
+			_, err = ws.GetRollupForRange(time.Minute, startTime, startTime+24*60*60)
+			Expect(err).ToNot(BeNil())
+			_, err = ws.GetRollupForRange(time.Second, startTime, startTime+120)
+			Expect(err).ToNot(BeNil())
+			_, err = ws.GetRollupForRange(time.Hour, startTime+1, startTime)
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("restores rollups from a snapshot that only has the periods with hits", func() {
+			ws.AddEntry("a", startTime)
+			ws.AddEntry("b", startTime+60)
+			snapshot := ws.Snapshot()
+			Expect(snapshot.Rollups).To(HaveLen(2))
+			Expect(snapshot.Rollups[0].Resolution).To(Equal(time.Minute))
+			Expect(snapshot.Rollups[0].Periods).To(Equal([]uint64{startTime / 60, startTime/60 + 1}))
+			Expect(snapshot.Rollups[1].Periods).To(Equal([]uint64{startTime / 3600}))
+
+			// the hour rollup is kept for longer, and the minute one isn't set so its snapshot is skipped
+			restored, _ := webstats.InitWebStats(120, 10, startTime)
+			Expect(restored.SetRollups([]webstats.Rollup{{Resolution: time.Hour, Retention: 48 * time.Hour}})).To(BeNil())
+			Expect(restored.Restore(snapshot)).To(BeNil())
+
+			hours, err := restored.GetRollupForRange(time.Hour, startTime, startTime)
+			Expect(err).To(BeNil())
+			Expect(hours[0].Sections).To(Equal(map[string]uint64{"a": 1, "b": 1}))
+			_, err = restored.GetRollupForRange(time.Minute, startTime, startTime)
+			Expect(err).ToNot(BeNil())
+
+			snapshot.Rollups[0].Periods = snapshot.Rollups[0].Periods[:1]
+			Expect(restored.Restore(snapshot)).ToNot(BeNil())
+		})
+
+		It("doesn't keep rollups unless they're set", func() {
+			localWs, _ := webstats.InitWebStats(120, 10, startTime)
+			localWs.AddEntry("a", startTime)
+			_, err := localWs.GetRollupForRange(time.Minute, startTime, startTime)
+			Expect(err).ToNot(BeNil())
+			Expect(localWs.Snapshot().Rollups).To(BeEmpty())
+		})
+
+		It("parses rollups", func() {
+			rollups, err := webstats.ParseRollups(" 1h:720h, 1m:24h ,")
+			Expect(err).To(BeNil())
+			Expect(rollups).To(Equal([]webstats.Rollup{
+				{Resolution: time.Minute, Retention: 24 * time.Hour},
+				{Resolution: time.Hour, Retention: 720 * time.Hour},
+			}))
+
+			rollups, err = webstats.ParseRollups("")
+			Expect(err).To(BeNil())
+			Expect(rollups).To(BeEmpty())
+
+			for _, invalid := range []string{"1m", "x:24h", "1m:x", "1s:1h", "1500ms:1h", "1m:90s", "1m:1m", "1s:1000h", "1m:1h,1m:2h"} {
+				_, err = webstats.ParseRollups(invalid)
+				Expect(err).ToNot(BeNil(), invalid)
+			}
+			Expect(ws.SetRollups([]webstats.Rollup{{Resolution: time.Minute, Retention: time.Minute}})).ToNot(BeNil())
+		})
+
+		It("finds the finest rollup an interval can be read from", func() {
+			rollups, _ := webstats.ParseRollups(webstats.DefaultRollups)
+			rollup, ok := webstats.RollupForInterval(rollups, 15*60)
+			Expect(ok).To(Equal(true))
+			Expect(rollup.Resolution).To(Equal(time.Minute))
+
+			rollup, ok = webstats.RollupForInterval(rollups, 24*60*60)
+			Expect(ok).To(Equal(true))
+			Expect(rollup.Resolution).To(Equal(time.Hour))
+
+			_, ok = webstats.RollupForInterval(rollups, 15*60+1)
+			Expect(ok).To(Equal(false))
+			_, ok = webstats.RollupForInterval(rollups, 16*24*60*60)
+			Expect(ok).To(Equal(false))
+		})
+	})
 })